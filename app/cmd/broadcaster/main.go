@@ -0,0 +1,76 @@
+// Command broadcaster delivers stored events to the matching subscriptions, so
+// the EVENT function can run with FANOUT_MODE=stream and return as soon as the
+// event is stored. It follows the change stream of the events collection, or
+// polls it when change streams are not available, as on a local standalone server.
+//
+// Usage:
+//
+//	broadcaster -secret test/nostr/mongo/rw -endpoint https://abc123.execute-api.us-east-1.amazonaws.com/test
+//	broadcaster -secret file://local.yaml -endpoint http://localhost:3001 -source poll
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/domain/fanout"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+func main() {
+	secret := flag.String("secret", env.GetStringOrDefault("DB_SECRET", ""), "the secret with the database configuration")
+	endpoint := flag.String("endpoint", env.GetStringOrDefault("WS_API_ENDPOINT", ""), "the management endpoint of the websocket stage")
+	source := flag.String("source", string(events.FeedAuto), "how to follow new events: auto, stream or poll")
+	pollInterval := flag.Duration("poll-interval", time.Second, "the interval between polls")
+	concurrency := flag.Int("concurrency", 8, "the number of connections posted to at the same time")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, *secret, *endpoint, events.FeedSource(*source), *pollInterval, *concurrency); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, secret, endpoint string, source events.FeedSource, pollInterval time.Duration, concurrency int) error {
+	if secret == "" || endpoint == "" {
+		return fmt.Errorf("both -secret and -endpoint are required")
+	}
+	poster, err := apigateway.NewConnectionPoster(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	db := skmongo.NewLazy(secret, nil)
+	defer db.Close()
+
+	eventsSvc := events.NewService(events.WithRepo(events.NewRepository(db)), events.WithPollInterval(pollInterval))
+	subs := subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db)))
+	broadcaster := fanout.NewService(fanout.WithSubscriptions(subs), fanout.WithPoster(poster), fanout.WithConcurrency(concurrency))
+
+	log.WithField("source", source).Info("broadcasting stored events")
+	err = eventsSvc.Follow(ctx, source, func(e events.Event) error {
+		delivered, err := broadcaster.Broadcast(ctx, e)
+		if err != nil {
+			log.WithField("id", e.ID).WithError(err).Error("failed to broadcast event")
+		}
+		log.WithField("id", e.ID).WithField("delivered", delivered).Debug("broadcast event")
+		return nil
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
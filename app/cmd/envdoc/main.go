@@ -0,0 +1,277 @@
+// Command envdoc lists the environment variables that each function reads, from
+// the env tags of the config struct in its package, see env.Load.
+//
+// Usage:
+//
+//	envdoc -o functions/ENVIRONMENT.md functions
+//
+//go:generate go run . -o ../../functions/ENVIRONMENT.md ../../functions
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configType is the name of the struct that the functions load with env.Load
+const configType = "config"
+
+// variable is an environment variable that a function reads
+type variable struct {
+	Name     string
+	Type     string
+	Required bool
+	Default  string
+}
+
+func main() {
+	out := flag.String("o", "", "the file to write the listing to, defaults to stdout")
+	flag.Parse()
+
+	if err := run(flag.Arg(0), *out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, out string) error {
+	if dir == "" {
+		return fmt.Errorf("usage: envdoc [-o file] functions-dir")
+	}
+	module, root, err := findModule(dir)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	l := lister{module: module, root: root, fset: token.NewFileSet(), packages: map[string]*ast.Package{}}
+	var b bytes.Buffer
+	b.WriteString("# Environment variables\n\n")
+	b.WriteString("Generated by `go generate ./cmd/envdoc` from the env tags of the config of each function. Do not edit.\n")
+	b.WriteString("\nOutside Lambda the functions also read the `.env` and `.env.{APP_ENV}` files in their working directory, ")
+	b.WriteString("which the environment takes precedence over. With `CONFIG_DEBUG=true` they write each value and its source to stderr.\n")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		variables, err := l.list(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("listing %s: %w", entry.Name(), err)
+		}
+		if variables == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", entry.Name())
+		b.WriteString("| Variable | Type | Required | Default |\n")
+		b.WriteString("|----------|------|----------|---------|\n")
+		for _, v := range variables {
+			required := ""
+			if v.Required {
+				required = "yes"
+			}
+			def := ""
+			if v.Default != "" {
+				def = "`" + v.Default + "`"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", v.Name, v.Type, required, def)
+		}
+	}
+	if out == "" {
+		_, err = os.Stdout.Write(b.Bytes())
+		return err
+	}
+	return os.WriteFile(out, b.Bytes(), 0o644)
+}
+
+// findModule returns the path and the root directory of the module that dir is in
+func findModule(dir string) (string, string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for {
+		f, err := os.Open(filepath.Join(root, "go.mod"))
+		if err == nil {
+			defer f.Close()
+			return modulePath(f), root, nil
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", "", fmt.Errorf("%s is not in a module", dir)
+		}
+		root = parent
+	}
+}
+
+func modulePath(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if module, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); found {
+			return strings.Trim(strings.TrimSpace(module), `"`)
+		}
+	}
+	return ""
+}
+
+// lister finds the variables of config structs, following nested structs into the packages of the module
+type lister struct {
+	module   string
+	root     string
+	fset     *token.FileSet
+	packages map[string]*ast.Package
+}
+
+// list returns the variables of the config struct in the package in dir, or nil when there is none
+func (l *lister) list(dir string) ([]variable, error) {
+	spec, file, err := l.findType(dir, configType)
+	if err != nil || spec == nil {
+		return nil, err
+	}
+	variables, err := l.fields(dir, file, spec.Type.(*ast.StructType), "")
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	return variables, err
+}
+
+func (l *lister) fields(dir string, file *ast.File, s *ast.StructType, prefix string) ([]variable, error) {
+	var variables []variable
+	for _, field := range s.Fields.List {
+		if len(field.Names) > 0 && !field.Names[0].IsExported() {
+			continue
+		}
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			value, err := strconv.Unquote(field.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(value)
+		}
+		options := strings.Split(tag.Get("env"), ",")
+		name := options[0]
+		nestedDir, nestedFile, nested, err := l.nestedStruct(dir, file, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		if nested != nil {
+			nestedVariables, err := l.fields(nestedDir, nestedFile, nested, prefix+name)
+			if err != nil {
+				return nil, err
+			}
+			variables = append(variables, nestedVariables...)
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		variables = append(variables, variable{
+			Name:     prefix + name,
+			Type:     types.ExprString(field.Type),
+			Required: slices.Contains(options[1:], "required"),
+			Default:  tag.Get("default"),
+		})
+	}
+	return variables, nil
+}
+
+// nestedStruct returns the struct type of a field, when it is declared in the module
+func (l *lister) nestedStruct(dir string, file *ast.File, expr ast.Expr) (string, *ast.File, *ast.StructType, error) {
+	var typeName string
+	switch t := expr.(type) {
+	case *ast.Ident:
+		typeName = t.Name
+	case *ast.SelectorExpr:
+		pkg, ok := t.X.(*ast.Ident)
+		if !ok {
+			return "", nil, nil, nil
+		}
+		dir = l.importDir(file, pkg.Name)
+		if dir == "" {
+			return "", nil, nil, nil
+		}
+		typeName = t.Sel.Name
+	default:
+		return "", nil, nil, nil
+	}
+	spec, typeFile, err := l.findType(dir, typeName)
+	if err != nil || spec == nil {
+		return "", nil, nil, err
+	}
+	s, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return "", nil, nil, nil
+	}
+	return dir, typeFile, s, nil
+}
+
+// importDir returns the directory of the package imported as name, when it is in the module
+func (l *lister) importDir(file *ast.File, name string) string {
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		imported := filepath.Base(path)
+		if spec.Name != nil {
+			imported = spec.Name.Name
+		}
+		if imported != name {
+			continue
+		}
+		if rel, found := strings.CutPrefix(path, l.module+"/"); found {
+			return filepath.Join(l.root, filepath.FromSlash(rel))
+		}
+	}
+	return ""
+}
+
+func (l *lister) findType(dir, name string) (*ast.TypeSpec, *ast.File, error) {
+	pkg, err := l.parse(dir)
+	if err != nil || pkg == nil {
+		return nil, nil, err
+	}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				if typeSpec := spec.(*ast.TypeSpec); typeSpec.Name.Name == name {
+					return typeSpec, file, nil
+				}
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+func (l *lister) parse(dir string) (*ast.Package, error) {
+	if pkg, found := l.packages[dir]; found {
+		return pkg, nil
+	}
+	pkgs, err := parser.ParseDir(l.fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	l.packages[dir] = pkg
+	return pkg, nil
+}
@@ -0,0 +1,99 @@
+// Command events imports and exports events as JSONL.
+//
+// Usage:
+//
+//	events export -secret test/nostr/mongo/rw -filter '{"kinds":[0,3]}' > events.jsonl
+//	events import -secret test/nostr/mongo/rw -batch 1000 < events.jsonl
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "import":
+		err = importEvents(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: events export|import [flags]")
+	os.Exit(2)
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	secret := flags.String("secret", env.GetStringOrDefault("DB_SECRET", ""), "the secret with the database configuration")
+	filterJSON := flags.String("filter", "{}", "the NIP-01 filter selecting the events to export")
+	out := flags.String("out", "", "the file to write to, defaults to stdout")
+	_ = flags.Parse(args)
+
+	var filter events.Filter
+	if err := json.Unmarshal([]byte(*filterJSON), &filter); err != nil {
+		return fmt.Errorf("invalid filter: %w", err)
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	db, closeDb := skmongo.MustFromSecretWithClose(*secret)
+	defer closeDb()
+	svc := events.NewService(events.WithRepo(events.NewRepository(db)))
+	count, err := svc.Export(context.Background(), filter, w)
+	fmt.Fprintf(os.Stderr, "exported %d events\n", count)
+	return err
+}
+
+func importEvents(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	secret := flags.String("secret", env.GetStringOrDefault("DB_SECRET", ""), "the secret with the database configuration")
+	in := flags.String("in", "", "the file to read from, defaults to stdin")
+	batchSize := flags.Int("batch", 500, "the number of events per bulk write")
+	_ = flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	db, closeDb := skmongo.MustFromSecretWithClose(*secret)
+	defer closeDb()
+	svc := events.NewService(events.WithRepo(events.NewRepository(db)), events.WithBatchSize(*batchSize))
+	report, err := svc.Import(context.Background(), r)
+	fmt.Fprintf(os.Stderr, "imported %d, skipped %d, duplicates %d, invalid %d\n",
+		report.Imported, report.Skipped, report.Duplicates, report.Invalid)
+	return err
+}
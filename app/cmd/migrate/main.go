@@ -0,0 +1,83 @@
+// Command migrate applies the database migrations in ops/migrations.
+//
+// Usage:
+//
+//	migrate -secret test/nostr/mongo/rw up
+//	migrate -secret test/nostr/mongo/rw -steps 1 down
+//	migrate -secret test/nostr/mongo/rw status
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+func main() {
+	secret := flag.String("secret", env.GetStringOrDefault("DB_SECRET", ""), "the secret with the database configuration")
+	dir := flag.String("dir", "", "the directory with the migrations, defaults to the nearest ops/migrations")
+	steps := flag.Int("steps", 1, "the number of migrations to revert with down")
+	lockTTL := flag.Duration("lock-ttl", 10*time.Minute, "how long the migration lock is held at most")
+	flag.Parse()
+
+	if err := run(flag.Arg(0), *secret, *dir, *steps, *lockTTL); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(command, secret, dir string, steps int, lockTTL time.Duration) error {
+	if dir == "" {
+		dir = skmongo.FindMigrationsDir()
+	}
+	if dir == "" {
+		return fmt.Errorf("no migrations directory found")
+	}
+	migrations, err := skmongo.LoadMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	db, closeDb := skmongo.MustFromSecretWithClose(secret)
+	defer closeDb()
+	migrator := skmongo.NewMigrator(db, migrations, skmongo.WithLockTTL(lockTTL))
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("usage: migrate [flags] up|down|status")
+	}
+}
@@ -2,53 +2,146 @@ package connections
 
 import (
 	"context"
+	"regexp"
 	"time"
 
-	"github.com/aws/aws-xray-sdk-go/xray"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
 )
 
 const collectionName = "connections"
 
-type connection struct {
+// Connection is a websocket connection of a client, with what the relay knows about it
+type Connection struct {
 	ID        string    `json:"id" bson:"id"`
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// LastSeenAt is when the client last sent a frame
+	LastSeenAt time.Time `json:"last_seen_at" bson:"last_seen_at"`
+	// ProbedAt is when the reaper last found the connection there, zero when it never probed it
+	ProbedAt   time.Time `json:"probed_at" bson:"probed_at,omitempty"`
+	SourceIP   string    `json:"source_ip" bson:"source_ip"`
+	UserAgent  string    `json:"user_agent" bson:"user_agent"`
+	Stage      string    `json:"stage" bson:"stage"`
+	DomainName string    `json:"domain_name" bson:"domain_name"`
+	// Pubkey is the public key the client authenticated with, nil when it did not.
+	// It stays nil until the relay implements NIP-42 AUTH, as the $connect route
+	// has no authorizer.
+	Pubkey *string `json:"pubkey" bson:"pubkey,omitempty"`
+	// Subscriptions is the number of open subscriptions
+	Subscriptions int `json:"subscriptions" bson:"subscriptions"`
+	// BytesIn is the size of the frames the client sent
+	BytesIn int64 `json:"bytes_in" bson:"bytes_in"`
+	// BytesOut is the size of the replies and stored events sent to the client.
+	// Broadcast events are not counted, so delivering them needs no writes.
+	BytesOut int64 `json:"bytes_out" bson:"bytes_out"`
+}
+
+// Query selects connections, the zero value selects all of them
+type Query struct {
+	SourceIP string
+	Pubkey   string
+	Stage    string
+	// UserAgent selects the connections with a user agent containing it, ignoring case
+	UserAgent string
+	// IdleSince selects the connections that were not seen since then
+	IdleSince time.Time
+	Limit     int
+	// Token is the Next token of the previous page, empty for the first page
+	Token string
+}
+
+func (q Query) filter() bson.M {
+	filter := bson.M{}
+	if q.SourceIP != "" {
+		filter["source_ip"] = q.SourceIP
+	}
+	if q.Pubkey != "" {
+		filter["pubkey"] = q.Pubkey
+	}
+	if q.Stage != "" {
+		filter["stage"] = q.Stage
+	}
+	if q.UserAgent != "" {
+		filter["user_agent"] = bson.M{"$regex": regexp.QuoteMeta(q.UserAgent), "$options": "i"}
+	}
+	if !q.IdleSince.IsZero() {
+		filter["last_seen_at"] = bson.M{"$lt": q.IdleSince}
+	}
+	return filter
 }
 
 type Repository interface {
-	add(ctx context.Context, con connection) error
+	add(ctx context.Context, con Connection) error
+	get(ctx context.Context, id string) (Connection, error)
 	remove(ctx context.Context, id string) error
+	probed(ctx context.Context, id string, at time.Time) error
+	record(ctx context.Context, id string, activity Activity) error
+	// stale calls fn for each connection last seen and probed before idleBefore, or created before createdBefore
+	stale(ctx context.Context, idleBefore, createdBefore time.Time, fn func(Connection) error) error
+	// list returns a page of the connections matching the query, newest first
+	list(ctx context.Context, q Query) (skmongo.Page[Connection], error)
 }
 
 type repository struct {
-	c *mongo.Collection
+	c skmongo.TypedCollection[Connection]
 }
 
 func MustNewRepository(secret string) Repository {
-	return &repository{
-		c: skmongo.MustFromSecret(secret).Collection(collectionName),
-	}
+	return NewRepository(skmongo.MustFromSecret(secret))
 }
 
-func NewRepository(db skmongo.Mongo) Repository {
+func NewRepository(db skmongo.CollectionProvider) Repository {
 	return &repository{
-		c: db.Collection(collectionName),
+		c: skmongo.NewTypedCollection[Connection](db, collectionName),
 	}
 }
 
-func (r *repository) add(ctx context.Context, con connection) error {
-	return xray.Capture(ctx, "DB - add connection", func(ctx1 context.Context) error {
-		_, err := r.c.InsertOne(ctx1, con)
-		return err
-	})
+func (r *repository) add(ctx context.Context, con Connection) error {
+	return r.c.InsertOne(ctx, con)
+}
+
+func (r *repository) get(ctx context.Context, id string) (Connection, error) {
+	return r.c.FindOne(ctx, bson.M{"id": id})
 }
 
 func (r *repository) remove(ctx context.Context, id string) error {
-	return xray.Capture(ctx, "DB - remove connection", func(ctx1 context.Context) error {
-		_, err := r.c.DeleteOne(ctx1, bson.M{"id": id})
-		return err
+	_, err := r.c.DeleteMany(ctx, bson.M{"id": id})
+	return err
+}
+
+func (r *repository) probed(ctx context.Context, id string, at time.Time) error {
+	return r.c.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$max": bson.M{"probed_at": at}})
+}
+
+func (r *repository) record(ctx context.Context, id string, activity Activity) error {
+	update := bson.M{
+		"$max": bson.M{"last_seen_at": activity.At},
+		"$inc": bson.M{"bytes_in": activity.BytesIn, "bytes_out": activity.BytesOut},
+	}
+	if activity.Subscriptions != nil {
+		update["$set"] = bson.M{"subscriptions": *activity.Subscriptions}
+	}
+	return r.c.UpdateOne(ctx, bson.M{"id": id}, update)
+}
+
+func (r *repository) stale(ctx context.Context, idleBefore, createdBefore time.Time, fn func(Connection) error) error {
+	return r.c.Find(ctx, staleFilter(idleBefore, createdBefore), fn)
+}
+
+func staleFilter(idleBefore, createdBefore time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		// $not also matches the connections that were never probed
+		bson.M{"last_seen_at": bson.M{"$lt": idleBefore}, "probed_at": bson.M{"$not": bson.M{"$gte": idleBefore}}},
+		bson.M{"created_at": bson.M{"$lt": createdBefore}},
+	}}
+}
+
+func (r *repository) list(ctx context.Context, q Query) (skmongo.Page[Connection], error) {
+	return r.c.Page(ctx, q.filter(), skmongo.PageRequest{
+		SortField:  "created_at",
+		Descending: true,
+		Limit:      q.Limit,
+		Token:      q.Token,
 	})
 }
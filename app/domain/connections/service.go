@@ -2,20 +2,80 @@ package connections
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+const (
+	// MaxConnectionAge is how long API Gateway keeps a websocket connection open at most
+	MaxConnectionAge = 2 * time.Hour
+	// defaultIdleTimeout is how long API Gateway keeps an idle connection open,
+	// after which the reaper probes if a connection is still there
+	defaultIdleTimeout = 10 * time.Minute
+	defaultListLimit   = 50
+	maxListLimit       = 500
 )
 
+// Prober checks if a connection is still there, returning apigateway.ErrGone when it is not
+type Prober interface {
+	Probe(ctx context.Context, connectionID string) error
+}
+
+// Disconnector closes connections, returning apigateway.ErrGone when a connection was already closed
+type Disconnector interface {
+	Disconnect(ctx context.Context, connectionID string) error
+}
+
+// Activity is what a client did in a frame
+type Activity struct {
+	At       time.Time
+	BytesIn  int
+	BytesOut int
+	// Subscriptions is the number of subscriptions after the frame, nil when the frame did not change them
+	Subscriptions *int
+}
+
+// ReapReport summarises a run of the reaper
+type ReapReport struct {
+	// Checked is the number of connections that were stale
+	Checked int `json:"checked"`
+	// Removed is the number of connections that were gone, or older than MaxConnectionAge
+	Removed int `json:"removed"`
+}
+
 type Service interface {
-	AddConnection(ctx context.Context, id string, at time.Time) error
+	AddConnection(ctx context.Context, con Connection) error
 	RemoveConnection(ctx context.Context, id string) error
+	Get(ctx context.Context, id string) (Connection, error)
+	List(ctx context.Context, q Query) (skmongo.Page[Connection], error)
+	Record(ctx context.Context, id string, activity Activity) error
+	RecordReplies(ctx context.Context, id string, activity *Activity, replies *apigateway.NostrResponder)
+	Disconnect(ctx context.Context, id string) error
+	Reap(ctx context.Context, now time.Time) (ReapReport, error)
 }
 
 type service struct {
-	repo Repository
+	repo         Repository
+	prober       Prober
+	disconnector Disconnector
+	onRemove     func(ctx context.Context, id string) error
+	idleTimeout  time.Duration
 }
 
 func NewService(opts ...func(svc *service)) Service {
-	svc := &service{}
+	svc := &service{
+		idleTimeout: defaultIdleTimeout,
+		onRemove: func(context.Context, string) error {
+			return nil
+		},
+	}
 	for _, opt := range opts {
 		opt(svc)
 	}
@@ -28,13 +88,155 @@ func WithRepo(repo Repository) func(svc *service) {
 	}
 }
 
-func (s *service) AddConnection(ctx context.Context, id string, at time.Time) error {
-	return s.repo.add(ctx, connection{
-		ID:        id,
-		CreatedAt: at,
-	})
+// WithProber sets the prober the reaper asks if stale connections are still there
+func WithProber(prober Prober) func(svc *service) {
+	return func(svc *service) {
+		svc.prober = prober
+	}
+}
+
+// WithDisconnector sets the disconnector that closes connections on Disconnect
+func WithDisconnector(disconnector Disconnector) func(svc *service) {
+	return func(svc *service) {
+		svc.disconnector = disconnector
+	}
+}
+
+// WithOnRemove sets the function the reaper, and Disconnect, call before removing a connection,
+// to remove what belongs to it, such as its subscriptions
+func WithOnRemove(onRemove func(ctx context.Context, id string) error) func(svc *service) {
+	return func(svc *service) {
+		svc.onRemove = onRemove
+	}
+}
+
+// WithIdleTimeout sets how long a connection is not seen before the reaper probes it
+func WithIdleTimeout(timeout time.Duration) func(svc *service) {
+	return func(svc *service) {
+		if timeout > 0 {
+			svc.idleTimeout = timeout
+		}
+	}
+}
+
+// FromRequest returns the connection that the $connect request opens at the time.
+func FromRequest(request awsevents.APIGatewayWebsocketProxyRequest, at time.Time) Connection {
+	requestContext := request.RequestContext
+	con := Connection{
+		ID:         requestContext.ConnectionID,
+		CreatedAt:  at,
+		LastSeenAt: at,
+		SourceIP:   requestContext.Identity.SourceIP,
+		UserAgent:  requestContext.Identity.UserAgent,
+		Stage:      requestContext.Stage,
+		DomainName: requestContext.DomainName,
+	}
+	if con.UserAgent == "" {
+		con.UserAgent = request.Headers["User-Agent"]
+	}
+	return con
+}
+
+func (s *service) AddConnection(ctx context.Context, con Connection) error {
+	return s.repo.add(ctx, con)
 }
 
 func (s *service) RemoveConnection(ctx context.Context, id string) error {
 	return s.repo.remove(ctx, id)
 }
+
+// Get returns the connection, or skmongo.ErrNotFound
+func (s *service) Get(ctx context.Context, id string) (Connection, error) {
+	return s.repo.get(ctx, id)
+}
+
+// List returns a page of the connections matching the query, newest first
+func (s *service) List(ctx context.Context, q Query) (skmongo.Page[Connection], error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultListLimit
+	}
+	if q.Limit > maxListLimit {
+		q.Limit = maxListLimit
+	}
+	return s.repo.list(ctx, q)
+}
+
+// Record records the activity of the client, which also tells the reaper that the connection is there
+func (s *service) Record(ctx context.Context, id string, activity Activity) error {
+	return s.repo.record(ctx, id, activity)
+}
+
+// RecordReplies logs the replies that failed, and records the activity with the size of the
+// replies sent for the frame. Functions defer it before handling a frame, so the activity
+// is read when it returns.
+func (s *service) RecordReplies(ctx context.Context, id string, activity *Activity, replies *apigateway.NostrResponder) {
+	if err := replies.Err(); err != nil {
+		log.WithError(err).WithField("connection", id).Warn("replying failed")
+	}
+	activity.BytesOut = replies.Sent()
+	if err := s.Record(ctx, id, *activity); err != nil {
+		log.WithError(err).WithField("connection", id).Error("recording activity failed")
+	}
+}
+
+// Disconnect closes the connection, and removes it. A connection that was
+// already closed is removed as well.
+func (s *service) Disconnect(ctx context.Context, id string) error {
+	if err := s.disconnector.Disconnect(ctx, id); err != nil && !errors.Is(err, apigateway.ErrGone) {
+		return fmt.Errorf("failed to close connection %s: %w", id, err)
+	}
+	return s.remove(ctx, id)
+}
+
+// Reap removes the connections that API Gateway never reported as disconnected.
+// Connections that were not seen, nor probed, for the idle timeout are probed,
+// and removed when they are gone. Connections older than MaxConnectionAge are removed
+// without probing, as API Gateway has closed them.
+func (s *service) Reap(ctx context.Context, now time.Time) (ReapReport, error) {
+	var report ReapReport
+	var stale []Connection
+	err := s.repo.stale(ctx, now.Add(-s.idleTimeout), now.Add(-MaxConnectionAge), func(con Connection) error {
+		stale = append(stale, con)
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to find stale connections: %w", err)
+	}
+	var errs []error
+	for _, con := range stale {
+		report.Checked++
+		gone, err := s.isGone(ctx, con, now)
+		if err == nil && gone {
+			err = s.remove(ctx, con.ID)
+			if err == nil {
+				report.Removed++
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("connection %s: %w", con.ID, err))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+func (s *service) isGone(ctx context.Context, con Connection, now time.Time) (bool, error) {
+	if con.CreatedAt.Before(now.Add(-MaxConnectionAge)) {
+		return true, nil
+	}
+	err := s.prober.Probe(ctx, con.ID)
+	if errors.Is(err, apigateway.ErrGone) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// the connection is there, so it is not probed again for the idle timeout
+	return false, s.repo.probed(ctx, con.ID, now)
+}
+
+func (s *service) remove(ctx context.Context, id string) error {
+	if err := s.onRemove(ctx, id); err != nil {
+		return err
+	}
+	return s.repo.remove(ctx, id)
+}
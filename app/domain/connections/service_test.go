@@ -0,0 +1,322 @@
+package connections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+func TestReap(t *testing.T) {
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	errProbe := errors.New("probe failed")
+	cases := map[string]struct {
+		connections []Connection
+		probeErrs   map[string]error
+		wantReport  ReapReport
+		wantErr     bool
+		wantKept    []string
+		wantProbed  []string
+	}{
+		"recently seen connections are not probed": {
+			connections: []Connection{{ID: "active", CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Minute)}},
+			wantKept:    []string{"active"},
+		},
+		"idle connections that are gone are removed": {
+			connections: []Connection{
+				{ID: "gone", CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Hour)},
+				{ID: "idle", CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Hour)},
+			},
+			probeErrs:  map[string]error{"gone": apigateway.ErrGone},
+			wantReport: ReapReport{Checked: 2, Removed: 1},
+			wantKept:   []string{"idle"},
+			wantProbed: []string{"gone", "idle"},
+		},
+		"connections past the maximum age are removed without probing": {
+			connections: []Connection{{ID: "old", CreatedAt: now.Add(-MaxConnectionAge - time.Second), LastSeenAt: now}},
+			wantReport:  ReapReport{Checked: 1, Removed: 1},
+		},
+		"probe failures are reported": {
+			connections: []Connection{
+				{ID: "failing", CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Hour)},
+				{ID: "gone", CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Hour)},
+			},
+			probeErrs:  map[string]error{"failing": errProbe, "gone": apigateway.ErrGone},
+			wantReport: ReapReport{Checked: 2, Removed: 1},
+			wantErr:    true,
+			wantKept:   []string{"failing"},
+			wantProbed: []string{"failing", "gone"},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			repo := &fakeRepository{connections: map[string]Connection{}}
+			for _, con := range testCase.connections {
+				repo.connections[con.ID] = con
+			}
+			prober := &fakeProber{errs: testCase.probeErrs}
+			var removed []string
+			svc := NewService(WithRepo(repo), WithProber(prober), WithOnRemove(func(_ context.Context, id string) error {
+				removed = append(removed, id)
+				return nil
+			}))
+
+			report, err := svc.Reap(context.Background(), now)
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			verify.Values(t, "report", report, testCase.wantReport)
+			verify.Values(t, "kept", repo.ids(), testCase.wantKept)
+			verify.Values(t, "probed", prober.probed, testCase.wantProbed)
+			verify.Values(t, "removed", len(removed), testCase.wantReport.Removed)
+		})
+	}
+}
+
+func TestReapRecordsProbesOfConnectionsThatAreThere(t *testing.T) {
+	now := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	repo := &fakeRepository{connections: map[string]Connection{
+		"idle": {ID: "idle", CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Hour)},
+	}}
+	prober := &fakeProber{}
+	svc := NewService(WithRepo(repo), WithProber(prober))
+	for _, at := range []time.Time{now, now.Add(time.Minute), now.Add(defaultIdleTimeout + time.Minute)} {
+		if _, err := svc.Reap(context.Background(), at); err != nil {
+			t.Fatalf("did not expect error %v", err)
+		}
+	}
+	verify.Values(t, "probed", prober.probed, []string{"idle", "idle"})
+	verify.Values(t, "last seen", repo.connections["idle"].LastSeenAt, now.Add(-time.Hour))
+	verify.Values(t, "probed at", repo.connections["idle"].ProbedAt, now.Add(defaultIdleTimeout+time.Minute))
+}
+
+func TestStaleFilter(t *testing.T) {
+	idleBefore := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	createdBefore := idleBefore.Add(-time.Hour)
+	verify.Values(t, "filter", staleFilter(idleBefore, createdBefore), bson.M{"$or": bson.A{
+		bson.M{"last_seen_at": bson.M{"$lt": idleBefore}, "probed_at": bson.M{"$not": bson.M{"$gte": idleBefore}}},
+		bson.M{"created_at": bson.M{"$lt": createdBefore}},
+	}})
+}
+
+func TestRecordReplies(t *testing.T) {
+	at := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	repo := &fakeRepository{connections: map[string]Connection{"con1": {ID: "con1"}}}
+	svc := NewService(WithRepo(repo))
+	poster := &fakePoster{}
+	replies := apigateway.NewNostrResponder(context.Background(), poster, "con1")
+	activity := Activity{At: at, BytesIn: 10}
+
+	func() {
+		defer svc.RecordReplies(context.Background(), "con1", &activity, replies)
+		_ = replies.Notice("slow down")
+	}()
+	verify.Values(t, "bytes out", activity.BytesOut, len(poster.sent))
+	verify.Values(t, "connection", repo.connections["con1"], Connection{
+		ID:         "con1",
+		LastSeenAt: at,
+		BytesIn:    10,
+		BytesOut:   int64(len(poster.sent)),
+	})
+}
+
+func TestFromRequest(t *testing.T) {
+	at := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	request := awsevents.APIGatewayWebsocketProxyRequest{
+		Headers: map[string]string{"User-Agent": "header agent"},
+		RequestContext: awsevents.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: "con1",
+			Stage:        "dev",
+			DomainName:   "relay.example.com",
+			Identity:     awsevents.APIGatewayRequestIdentity{SourceIP: "192.0.2.1"},
+		},
+	}
+	verify.Values(t, "connection", FromRequest(request, at), Connection{
+		ID:         "con1",
+		CreatedAt:  at,
+		LastSeenAt: at,
+		SourceIP:   "192.0.2.1",
+		UserAgent:  "header agent",
+		Stage:      "dev",
+		DomainName: "relay.example.com",
+	})
+}
+
+func TestQueryFilter(t *testing.T) {
+	idleSince := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		query Query
+		want  bson.M
+	}{
+		"all": {
+			want: bson.M{},
+		},
+		"exact fields": {
+			query: Query{SourceIP: "192.0.2.1", Pubkey: "pk", Stage: "dev"},
+			want:  bson.M{"source_ip": "192.0.2.1", "pubkey": "pk", "stage": "dev"},
+		},
+		"user agent is matched literally": {
+			query: Query{UserAgent: "bot.v1"},
+			want:  bson.M{"user_agent": bson.M{"$regex": `bot\.v1`, "$options": "i"}},
+		},
+		"idle": {
+			query: Query{IdleSince: idleSince},
+			want:  bson.M{"last_seen_at": bson.M{"$lt": idleSince}},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			verify.Values(t, "filter", testCase.query.filter(), testCase.want)
+		})
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	errDelete := errors.New("delete failed")
+	cases := map[string]struct {
+		disconnectErr error
+		wantErr       bool
+		wantKept      []string
+	}{
+		"open connection": {},
+		"connection that is gone": {
+			disconnectErr: apigateway.ErrGone,
+		},
+		"failure": {
+			disconnectErr: errDelete,
+			wantErr:       true,
+			wantKept:      []string{"con1"},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			repo := &fakeRepository{connections: map[string]Connection{"con1": {ID: "con1"}}}
+			var removed []string
+			svc := NewService(WithRepo(repo), WithDisconnector(&fakeDisconnector{err: testCase.disconnectErr}), WithOnRemove(func(_ context.Context, id string) error {
+				removed = append(removed, id)
+				return nil
+			}))
+
+			err := svc.Disconnect(context.Background(), "con1")
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			verify.Values(t, "kept", repo.ids(), testCase.wantKept)
+			verify.Values(t, "removed", len(removed), 1-len(testCase.wantKept))
+		})
+	}
+}
+
+/// Helper Types ///
+
+type fakeRepository struct {
+	connections map[string]Connection
+}
+
+func (r *fakeRepository) ids() []string {
+	var ids []string
+	for id := range r.connections {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (r *fakeRepository) add(_ context.Context, con Connection) error {
+	r.connections[con.ID] = con
+	return nil
+}
+
+func (r *fakeRepository) get(_ context.Context, id string) (Connection, error) {
+	con, found := r.connections[id]
+	if !found {
+		return con, skmongo.ErrNotFound
+	}
+	return con, nil
+}
+
+func (r *fakeRepository) remove(_ context.Context, id string) error {
+	delete(r.connections, id)
+	return nil
+}
+
+func (r *fakeRepository) probed(_ context.Context, id string, at time.Time) error {
+	con, found := r.connections[id]
+	if !found {
+		return fmt.Errorf("connection %s not found", id)
+	}
+	if at.After(con.ProbedAt) {
+		con.ProbedAt = at
+	}
+	r.connections[id] = con
+	return nil
+}
+
+func (r *fakeRepository) stale(_ context.Context, idleBefore, createdBefore time.Time, fn func(Connection) error) error {
+	for _, id := range r.ids() {
+		con := r.connections[id]
+		if con.LastSeenAt.Before(idleBefore) && con.ProbedAt.Before(idleBefore) || con.CreatedAt.Before(createdBefore) {
+			if err := fn(con); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) record(_ context.Context, id string, activity Activity) error {
+	con, found := r.connections[id]
+	if !found {
+		return fmt.Errorf("connection %s not found", id)
+	}
+	if activity.At.After(con.LastSeenAt) {
+		con.LastSeenAt = activity.At
+	}
+	con.BytesIn += int64(activity.BytesIn)
+	con.BytesOut += int64(activity.BytesOut)
+	if activity.Subscriptions != nil {
+		con.Subscriptions = *activity.Subscriptions
+	}
+	r.connections[id] = con
+	return nil
+}
+
+func (r *fakeRepository) list(context.Context, Query) (skmongo.Page[Connection], error) {
+	var page skmongo.Page[Connection]
+	for _, id := range r.ids() {
+		page.Items = append(page.Items, r.connections[id])
+	}
+	return page, nil
+}
+
+type fakeProber struct {
+	errs   map[string]error
+	probed []string
+}
+
+func (p *fakeProber) Probe(_ context.Context, connectionID string) error {
+	p.probed = append(p.probed, connectionID)
+	return p.errs[connectionID]
+}
+
+type fakePoster struct {
+	sent []byte
+}
+
+func (p *fakePoster) Post(_ context.Context, _ string, data []byte) error {
+	p.sent = append(p.sent, data...)
+	return nil
+}
+
+type fakeDisconnector struct {
+	err error
+}
+
+func (d *fakeDisconnector) Disconnect(context.Context, string) error {
+	return d.err
+}
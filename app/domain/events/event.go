@@ -0,0 +1,76 @@
+package events
+
+import (
+	"strconv"
+
+	"github.com/superkruger/nostr_app_data/app/utils/nostr"
+)
+
+var (
+	ErrInvalidID        = nostr.ErrInvalidID
+	ErrInvalidSignature = nostr.ErrInvalidSignature
+)
+
+// Event is a nostr event as described in NIP-01
+type Event struct {
+	ID        string     `json:"id" bson:"id"`
+	PubKey    string     `json:"pubkey" bson:"pubkey"`
+	CreatedAt int64      `json:"created_at" bson:"created_at"`
+	Kind      int        `json:"kind" bson:"kind"`
+	Tags      [][]string `json:"tags" bson:"tags"`
+	Content   string     `json:"content" bson:"content"`
+	Sig       string     `json:"sig" bson:"sig"`
+}
+
+// IsReplaceable tells if only the latest event per pubkey and kind is kept
+func (e Event) IsReplaceable() bool {
+	return e.Kind == 0 || e.Kind == 3 || (e.Kind >= 10000 && e.Kind < 20000)
+}
+
+// IsEphemeral tells if the event is not expected to be stored
+func (e Event) IsEphemeral() bool {
+	return e.Kind >= 20000 && e.Kind < 30000
+}
+
+// IsAddressable tells if only the latest event per pubkey, kind and d tag is kept
+func (e Event) IsAddressable() bool {
+	return e.Kind >= 30000 && e.Kind < 40000
+}
+
+// TagValue returns the first value of the first tag with the given name
+func (e Event) TagValue(name string) string {
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// ReplaceKey returns the key identifying the versions of a replaceable or addressable event,
+// or an empty string for regular events
+func (e Event) ReplaceKey() string {
+	switch {
+	case e.IsReplaceable():
+		return e.PubKey + ":" + strconv.Itoa(e.Kind)
+	case e.IsAddressable():
+		return e.PubKey + ":" + strconv.Itoa(e.Kind) + ":" + e.TagValue("d")
+	default:
+		return ""
+	}
+}
+
+// Serialize returns the canonical serialization used to compute the event id
+func (e Event) Serialize() ([]byte, error) {
+	return nostr.Event(e).Serialize()
+}
+
+// ComputeID returns the hex encoded sha256 of the serialized event
+func (e Event) ComputeID() (string, error) {
+	return nostr.Event(e).ComputeID()
+}
+
+// Verify checks that the id matches the content, and that the signature is valid for the pubkey
+func (e Event) Verify() error {
+	return nostr.Event(e).Verify()
+}
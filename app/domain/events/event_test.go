@@ -0,0 +1,87 @@
+package events
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestVerify(t *testing.T) {
+	key := mustNewKey(t)
+	cases := map[string]struct {
+		modify  func(e *Event)
+		wantErr error
+	}{
+		"valid":            {func(e *Event) {}, nil},
+		"changed content":  {func(e *Event) { e.Content = "changed" }, ErrInvalidID},
+		"changed sig":      {func(e *Event) { e.Sig = signed(t, key, Event{Content: "other"}).Sig }, ErrInvalidSignature},
+		"malformed pubkey": {func(e *Event) { e.PubKey = "zz"; e.ID, _ = e.ComputeID() }, ErrInvalidSignature},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := signed(t, key, Event{CreatedAt: 1700000000, Kind: 1, Tags: [][]string{{"t", "nostr"}}, Content: "hello <world> & co"})
+			testCase.modify(&e)
+			err := e.Verify()
+			verify.Values(t, name, errors.Is(err, testCase.wantErr), true)
+		})
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	e := Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Content: "a<b>&\"c\"\n"}
+	got, err := e.Serialize()
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "serialized", string(got), `[0,"abc",1,1,[],"a<b>&\"c\"\n"]`)
+}
+
+func TestReplaceKey(t *testing.T) {
+	cases := map[string]struct {
+		event Event
+		want  string
+	}{
+		"regular":     {Event{PubKey: "pk", Kind: 1}, ""},
+		"metadata":    {Event{PubKey: "pk", Kind: 0}, "pk:0"},
+		"contacts":    {Event{PubKey: "pk", Kind: 3}, "pk:3"},
+		"replaceable": {Event{PubKey: "pk", Kind: 10002}, "pk:10002"},
+		"ephemeral":   {Event{PubKey: "pk", Kind: 20001}, ""},
+		"addressable": {Event{PubKey: "pk", Kind: 30023, Tags: [][]string{{"d", "slug"}}}, "pk:30023:slug"},
+		"no d tag":    {Event{PubKey: "pk", Kind: 30023}, "pk:30023:"},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			verify.Values(t, name, testCase.event.ReplaceKey(), testCase.want)
+		})
+	}
+}
+
+/// Helper Functions ///
+
+func mustNewKey(t *testing.T) *btcec.PrivateKey {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	return key
+}
+
+func signed(t *testing.T, key *btcec.PrivateKey, e Event) Event {
+	e.PubKey = hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+	id, err := e.ComputeID()
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	e.ID = id
+	hash, _ := hex.DecodeString(id)
+	sig, err := schnorr.Sign(key, hash)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	e.Sig = hex.EncodeToString(sig.Serialize())
+	return e
+}
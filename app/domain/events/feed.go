@@ -0,0 +1,122 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+// FeedSource selects how Follow learns about newly stored events
+type FeedSource string
+
+const (
+	// FeedAuto uses the change stream, and falls back to polling when change streams are not supported
+	FeedAuto FeedSource = "auto"
+	// FeedChangeStream uses the change stream of the events collection, which needs a replica set
+	FeedChangeStream FeedSource = "stream"
+	// FeedPolling polls the events collection, as a stand-in for a local standalone server
+	FeedPolling FeedSource = "poll"
+)
+
+const (
+	defaultPollInterval = time.Second
+	// pollOverlap is how far back each poll looks before the last seen event, to
+	// pick up writes that committed after a write with a later stored_at
+	pollOverlap = 5 * time.Second
+)
+
+// WithPollInterval sets the interval between polls, and the delay before resuming an interrupted change stream
+func WithPollInterval(interval time.Duration) func(svc *service) {
+	return func(svc *service) {
+		if interval > 0 {
+			svc.pollInterval = interval
+		}
+	}
+}
+
+// Follow calls fn for each event stored from now on, until ctx is done or fn fails.
+// Ephemeral events are not stored, so they are not followed.
+func (s *service) Follow(ctx context.Context, source FeedSource, fn func(Event) error) error {
+	switch source {
+	case FeedChangeStream:
+		return s.watch(ctx, fn)
+	case FeedPolling:
+		return s.poll(ctx, fn)
+	case FeedAuto, "":
+		err := s.watch(ctx, fn)
+		if !skmongo.IsChangeStreamUnsupportedErr(err) {
+			return err
+		}
+		log.WithError(err).Warn("change streams are not supported, polling for events instead")
+		return s.poll(ctx, fn)
+	}
+	return fmt.Errorf("unknown feed source %q", source)
+}
+
+// watch follows the change stream, resuming it after transient errors
+func (s *service) watch(ctx context.Context, fn func(Event) error) error {
+	var resumeToken bson.Raw
+	for {
+		err := s.repo.watch(ctx, resumeToken, func(e Event, token bson.Raw) error {
+			if err := fn(e); err != nil {
+				return err
+			}
+			resumeToken = token
+			return nil
+		})
+		if !skmongo.IsTransientErr(err) {
+			return err
+		}
+		log.WithError(err).Warn("change stream interrupted, resuming")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// poll repeatedly reads the events stored since shortly before the last one it
+// saw, and skips the ones it already passed to fn, or that were stored before it started.
+func (s *service) poll(ctx context.Context, fn func(Event) error) error {
+	start := time.Now()
+	cursor := start
+	seen := map[string]time.Time{}
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := s.repo.storedSince(ctx, cursor.Add(-pollOverlap), func(e Event, storedAt time.Time) error {
+			if _, found := seen[e.ID]; found {
+				return nil
+			}
+			seen[e.ID] = storedAt
+			if storedAt.After(cursor) {
+				cursor = storedAt
+			}
+			if !storedAt.After(start) {
+				return nil
+			}
+			return fn(e)
+		})
+		if skmongo.IsTransientErr(err) {
+			log.WithError(err).Warn("polling for events failed, retrying")
+		} else if err != nil {
+			return err
+		}
+		for id, storedAt := range seen {
+			if storedAt.Before(cursor.Add(-pollOverlap)) {
+				delete(seen, id)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter is a nostr subscription filter as described in NIP-01
+type Filter struct {
+	IDs     []string `bson:"ids"`
+	Authors []string `bson:"authors"`
+	Kinds   []int    `bson:"kinds"`
+	// Tags holds the tag filters, keyed by the single letter tag name, without the #
+	Tags  map[string][]string `bson:"tags"`
+	Since *int64              `bson:"since,omitempty"`
+	Until *int64              `bson:"until,omitempty"`
+	Limit int                 `bson:"limit,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, collecting the #<letter> keys into Tags
+func (f *Filter) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*f = Filter{}
+	for key, value := range raw {
+		var err error
+		switch {
+		case key == "ids":
+			err = json.Unmarshal(value, &f.IDs)
+		case key == "authors":
+			err = json.Unmarshal(value, &f.Authors)
+		case key == "kinds":
+			err = json.Unmarshal(value, &f.Kinds)
+		case key == "since":
+			err = json.Unmarshal(value, &f.Since)
+		case key == "until":
+			err = json.Unmarshal(value, &f.Until)
+		case key == "limit":
+			err = json.Unmarshal(value, &f.Limit)
+		case strings.HasPrefix(key, "#") && len(key) == 2:
+			var values []string
+			err = json.Unmarshal(value, &values)
+			if f.Tags == nil {
+				f.Tags = map[string][]string{}
+			}
+			f.Tags[key[1:]] = values
+		}
+		if err != nil {
+			return fmt.Errorf("invalid filter field %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, writing Tags as #<letter> keys
+func (f Filter) MarshalJSON() ([]byte, error) {
+	raw := map[string]interface{}{}
+	if f.IDs != nil {
+		raw["ids"] = f.IDs
+	}
+	if f.Authors != nil {
+		raw["authors"] = f.Authors
+	}
+	if f.Kinds != nil {
+		raw["kinds"] = f.Kinds
+	}
+	for name, values := range f.Tags {
+		raw["#"+name] = values
+	}
+	if f.Since != nil {
+		raw["since"] = *f.Since
+	}
+	if f.Until != nil {
+		raw["until"] = *f.Until
+	}
+	if f.Limit > 0 {
+		raw["limit"] = f.Limit
+	}
+	return json.Marshal(raw)
+}
+
+// Matches tells if the event passes all conditions of the filter
+func (f Filter) Matches(e Event) bool {
+	if f.IDs != nil && !containsString(f.IDs, e.ID) {
+		return false
+	}
+	if f.Authors != nil && !containsString(f.Authors, e.PubKey) {
+		return false
+	}
+	if f.Kinds != nil && !containsInt(f.Kinds, e.Kind) {
+		return false
+	}
+	if f.Since != nil && e.CreatedAt < *f.Since {
+		return false
+	}
+	if f.Until != nil && e.CreatedAt > *f.Until {
+		return false
+	}
+	for name, values := range f.Tags {
+		if !hasTag(e, name, values) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesAny tells if the event matches at least one of the filters
+func MatchesAny(filters []Filter, e Event) bool {
+	for _, f := range filters {
+		if f.Matches(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(e Event, name string, values []string) bool {
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && tag[0] == name && containsString(values, tag[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"sort"
+	"strconv"
+)
+
+// MatchAllKey is the index key of filters without ids, authors, tags or kinds,
+// which have to be checked for every event
+const MatchAllKey = "*"
+
+// IndexKeys returns the keys under which the filter is indexed. As an event has
+// to pass all conditions of a filter, the filter is only indexed on its most
+// selective condition: ids, then authors, then the tag with the fewest values,
+// then kinds. An event can only match the filter when it has one of these keys.
+func (f Filter) IndexKeys() []string {
+	switch {
+	case f.IDs != nil:
+		return prefixed("i:", f.IDs)
+	case f.Authors != nil:
+		return prefixed("a:", f.Authors)
+	case len(f.Tags) > 0:
+		names := make([]string, 0, len(f.Tags))
+		for name := range f.Tags {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if len(f.Tags[names[i]]) != len(f.Tags[names[j]]) {
+				return len(f.Tags[names[i]]) < len(f.Tags[names[j]])
+			}
+			return names[i] < names[j]
+		})
+		return prefixed("t:"+names[0]+":", f.Tags[names[0]])
+	case f.Kinds != nil:
+		keys := make([]string, len(f.Kinds))
+		for i, kind := range f.Kinds {
+			keys[i] = kindKey(kind)
+		}
+		return keys
+	}
+	return []string{MatchAllKey}
+}
+
+// IndexKeys returns the keys of the filters the event may match
+func (e Event) IndexKeys() []string {
+	keys := []string{MatchAllKey, "i:" + e.ID, "a:" + e.PubKey, kindKey(e.Kind)}
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && len(tag[0]) == 1 {
+			keys = append(keys, "t:"+tag[0]+":"+tag[1])
+		}
+	}
+	return keys
+}
+
+func kindKey(kind int) string {
+	return "k:" + strconv.Itoa(kind)
+}
+
+func prefixed(prefix string, values []string) []string {
+	keys := make([]string, len(values))
+	for i, v := range values {
+		keys[i] = prefix + v
+	}
+	return keys
+}
@@ -0,0 +1,32 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestFilterIndexKeys(t *testing.T) {
+	cases := map[string]struct {
+		filter Filter
+		want   []string
+	}{
+		"ids first":             {Filter{IDs: []string{"x"}, Authors: []string{"pk"}}, []string{"i:x"}},
+		"authors before kinds":  {Filter{Authors: []string{"pk1", "pk2"}, Kinds: []int{1}}, []string{"a:pk1", "a:pk2"}},
+		"tag with least values": {Filter{Tags: map[string][]string{"e": {"1", "2"}, "p": {"pk"}}, Kinds: []int{1}}, []string{"t:p:pk"}},
+		"tags by name on ties":  {Filter{Tags: map[string][]string{"p": {"pk"}, "e": {"1"}}}, []string{"t:e:1"}},
+		"kinds":                 {Filter{Kinds: []int{0, 3}}, []string{"k:0", "k:3"}},
+		"broad":                 {Filter{Limit: 10}, []string{MatchAllKey}},
+		"matches nothing":       {Filter{IDs: []string{}}, []string{}},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			verify.Values(t, name, testCase.filter.IndexKeys(), testCase.want)
+		})
+	}
+}
+
+func TestEventIndexKeys(t *testing.T) {
+	e := Event{ID: "x", PubKey: "pk", Kind: 1, Tags: [][]string{{"e", "1"}, {"p", "pk2", "relay"}, {"alt", "text"}, {"t"}}}
+	verify.Values(t, "keys", e.IndexKeys(), []string{MatchAllKey, "i:x", "a:pk", "k:1", "t:e:1", "t:p:pk2"})
+}
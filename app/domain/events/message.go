@@ -0,0 +1,64 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+// The message types a client sends, see NIP-01. The relay sends its messages
+// with apigateway.NostrResponder.
+const (
+	MessageEvent = "EVENT"
+	MessageReq   = "REQ"
+	MessageClose = "CLOSE"
+)
+
+// ErrInvalidMessage is returned when a client message can not be parsed
+var ErrInvalidMessage = errkind.New(errkind.Validation, "invalid: message could not be parsed")
+
+// ClientMessage is a message sent by a client to the relay
+type ClientMessage struct {
+	Type string
+	// Event is set for EVENT messages
+	Event Event
+	// SubscriptionID is set for REQ and CLOSE messages
+	SubscriptionID string
+	// Filters is set for REQ messages
+	Filters []Filter
+}
+
+// ParseClientMessage parses an EVENT, REQ or CLOSE message
+func ParseClientMessage(b []byte) (ClientMessage, error) {
+	var msg ClientMessage
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil || len(raw) < 2 {
+		return msg, ErrInvalidMessage
+	}
+	if err := json.Unmarshal(raw[0], &msg.Type); err != nil {
+		return msg, ErrInvalidMessage
+	}
+	switch msg.Type {
+	case MessageEvent:
+		if err := json.Unmarshal(raw[1], &msg.Event); err != nil {
+			return msg, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+		}
+	case MessageReq, MessageClose:
+		if err := json.Unmarshal(raw[1], &msg.SubscriptionID); err != nil || msg.SubscriptionID == "" {
+			return msg, ErrInvalidMessage
+		}
+		if msg.Type == MessageClose {
+			break
+		}
+		msg.Filters = make([]Filter, len(raw)-2)
+		for i, f := range raw[2:] {
+			if err := json.Unmarshal(f, &msg.Filters[i]); err != nil {
+				return msg, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+			}
+		}
+	default:
+		return msg, fmt.Errorf("%w: unknown message type %s", ErrInvalidMessage, msg.Type)
+	}
+	return msg, nil
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestParseClientMessage(t *testing.T) {
+	cases := map[string]struct {
+		body    string
+		want    ClientMessage
+		wantErr error
+	}{
+		"event": {
+			body: `["EVENT",{"id":"abc","pubkey":"pk","created_at":1,"kind":1,"tags":[],"content":"hi","sig":"s"}]`,
+			want: ClientMessage{Type: MessageEvent, Event: Event{ID: "abc", PubKey: "pk", CreatedAt: 1, Kind: 1, Tags: [][]string{}, Content: "hi", Sig: "s"}},
+		},
+		"req": {
+			body: `["REQ","sub",{"kinds":[1]},{"#e":["abc"]}]`,
+			want: ClientMessage{Type: MessageReq, SubscriptionID: "sub", Filters: []Filter{{Kinds: []int{1}}, {Tags: map[string][]string{"e": {"abc"}}}}},
+		},
+		"close": {
+			body: `["CLOSE","sub"]`,
+			want: ClientMessage{Type: MessageClose, SubscriptionID: "sub"},
+		},
+		"not an array":            {body: `{"type":"EVENT"}`, wantErr: ErrInvalidMessage},
+		"unknown type":            {body: `["AUTH","challenge"]`, want: ClientMessage{Type: "AUTH"}, wantErr: ErrInvalidMessage},
+		"req without id":          {body: `["REQ",""]`, want: ClientMessage{Type: MessageReq}, wantErr: ErrInvalidMessage},
+		"req with invalid filter": {body: `["REQ","sub",{"kinds":"1"}]`, want: ClientMessage{Type: MessageReq, SubscriptionID: "sub", Filters: []Filter{{}}}, wantErr: ErrInvalidMessage},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseClientMessage([]byte(testCase.body))
+			verify.Values(t, "error", errors.Is(err, testCase.wantErr), true)
+			verify.Values(t, "message", got, testCase.want)
+		})
+	}
+}
@@ -1 +1,168 @@
 package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+const collectionName = "events"
+
+// storedEvent is the event as persisted, with denormalized fields for querying
+type storedEvent struct {
+	Event      `bson:",inline"`
+	ReplaceKey string   `bson:"replace_key,omitempty"`
+	TagValues  []string `bson:"tag_values,omitempty"`
+	// StoredAt is when the event was written, which orders the events for polling
+	StoredAt time.Time `bson:"stored_at"`
+}
+
+// writeOutcome is the result of a single write in a bulk write
+type writeOutcome int
+
+const (
+	written writeOutcome = iota
+	// conflicted means a unique index prevented the write, either because the
+	// event id is already stored or a newer version of a replaceable event is.
+	conflicted
+)
+
+type Repository interface {
+	query(ctx context.Context, filter Filter, fn func(Event) error) error
+	bulkWrite(ctx context.Context, batch []Event) ([]writeOutcome, error)
+	// watch calls fn for each event written after the resume token, or from now when it is nil
+	watch(ctx context.Context, resumeAfter bson.Raw, fn func(e Event, resumeToken bson.Raw) error) error
+	// storedSince calls fn for each event stored at or after the time, in the order they were stored
+	storedSince(ctx context.Context, since time.Time, fn func(e Event, storedAt time.Time) error) error
+}
+
+type repository struct {
+	c skmongo.TypedCollection[storedEvent]
+}
+
+func MustNewRepository(secret string) Repository {
+	return NewRepository(skmongo.MustFromSecret(secret))
+}
+
+func NewRepository(db skmongo.CollectionProvider) Repository {
+	return &repository{
+		c: skmongo.NewTypedCollection[storedEvent](db, collectionName),
+	}
+}
+
+// query returns the events oldest first, or when the filter has a limit, the newest events newest first
+func (r *repository) query(ctx context.Context, filter Filter, fn func(Event) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(filter.Limit))
+	}
+	return r.c.Find(ctx, toQuery(filter), func(e storedEvent) error {
+		return fn(e.Event)
+	}, opts)
+}
+
+func (r *repository) bulkWrite(ctx context.Context, batch []Event) ([]writeOutcome, error) {
+	outcomes := make([]writeOutcome, len(batch))
+	models := make([]mongo.WriteModel, len(batch))
+	now := time.Now()
+	for i, e := range batch {
+		models[i] = writeModel(e, now)
+	}
+	_, err := r.c.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		return outcomes, err
+	}
+	if bulkErr.WriteConcernError != nil {
+		return outcomes, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return outcomes, err
+		}
+		outcomes[writeErr.Index] = conflicted
+	}
+	return outcomes, nil
+}
+
+func (r *repository) watch(ctx context.Context, resumeAfter bson.Raw, fn func(e Event, resumeToken bson.Raw) error) error {
+	return r.c.Watch(ctx, resumeAfter, func(doc storedEvent, resumeToken bson.Raw) error {
+		return fn(doc.Event, resumeToken)
+	})
+}
+
+func (r *repository) storedSince(ctx context.Context, since time.Time, fn func(e Event, storedAt time.Time) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "stored_at", Value: 1}})
+	return r.c.Find(ctx, bson.M{"stored_at": bson.M{"$gte": since}}, func(doc storedEvent) error {
+		return fn(doc.Event, doc.StoredAt)
+	}, opts)
+}
+
+// writeModel inserts regular events, and upserts replaceable events only when
+// the stored version is older, or as old with a higher id as NIP-01 keeps the
+// lowest id. When another version is kept, the upsert collides with the unique
+// replace_key index and is reported as a conflict.
+func writeModel(e Event, at time.Time) mongo.WriteModel {
+	doc := toStored(e, at)
+	if doc.ReplaceKey == "" {
+		return mongo.NewInsertOneModel().SetDocument(doc)
+	}
+	return mongo.NewReplaceOneModel().
+		SetFilter(bson.M{"replace_key": doc.ReplaceKey, "$or": bson.A{
+			bson.M{"created_at": bson.M{"$lt": e.CreatedAt}},
+			bson.M{"created_at": e.CreatedAt, "id": bson.M{"$gt": e.ID}},
+		}}).
+		SetReplacement(doc).
+		SetUpsert(true)
+}
+
+func toStored(e Event, at time.Time) storedEvent {
+	doc := storedEvent{Event: e, ReplaceKey: e.ReplaceKey(), StoredAt: at}
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && len(tag[0]) == 1 {
+			doc.TagValues = append(doc.TagValues, tag[0]+":"+tag[1])
+		}
+	}
+	return doc
+}
+
+func toQuery(f Filter) bson.M {
+	query := bson.M{}
+	if f.IDs != nil {
+		query["id"] = bson.M{"$in": f.IDs}
+	}
+	if f.Authors != nil {
+		query["pubkey"] = bson.M{"$in": f.Authors}
+	}
+	if f.Kinds != nil {
+		query["kind"] = bson.M{"$in": f.Kinds}
+	}
+	createdAt := bson.M{}
+	if f.Since != nil {
+		createdAt["$gte"] = *f.Since
+	}
+	if f.Until != nil {
+		createdAt["$lte"] = *f.Until
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+	var tagConditions bson.A
+	for name, values := range f.Tags {
+		tagValues := make([]string, len(values))
+		for i, v := range values {
+			tagValues[i] = name + ":" + v
+		}
+		tagConditions = append(tagConditions, bson.M{"tag_values": bson.M{"$in": tagValues}})
+	}
+	if len(tagConditions) > 0 {
+		query["$and"] = tagConditions
+	}
+	return query
+}
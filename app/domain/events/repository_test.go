@@ -0,0 +1,23 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestWriteModel(t *testing.T) {
+	profile := Event{ID: "b", PubKey: "pubkey", CreatedAt: 100, Kind: 0}
+	model, ok := writeModel(profile, time.Now()).(*mongo.ReplaceOneModel)
+	if !ok {
+		t.Fatalf("expected a replace of the replaceable event")
+	}
+	verify.Values(t, "filter", model.Filter, bson.M{"replace_key": profile.ReplaceKey(), "$or": bson.A{
+		bson.M{"created_at": bson.M{"$lt": int64(100)}},
+		bson.M{"created_at": int64(100), "id": bson.M{"$gt": "b"}},
+	}})
+	verify.Values(t, "upsert", *model.Upsert, true)
+}
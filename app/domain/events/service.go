@@ -1 +1,244 @@
 package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+const (
+	defaultBatchSize = 500
+	maxLineSize      = 4 * 1024 * 1024
+)
+
+var (
+	// ErrDuplicate is returned when the event is already stored
+	ErrDuplicate = errkind.New(errkind.Conflict, "duplicate: already have this event")
+	// ErrOutdated is returned when a newer version of a replaceable event is stored
+	ErrOutdated = errkind.New(errkind.Conflict, "duplicate: have a newer version of this event")
+	// ErrBlocked is wrapped by the errors of a Policy rejecting an event
+	ErrBlocked = errkind.New(errkind.Unauthorized, "blocked")
+)
+
+// Policy decides which events the relay accepts
+type Policy interface {
+	// Check returns an error wrapping ErrBlocked when the event is not accepted
+	Check(ctx context.Context, e Event) error
+}
+
+// ImportReport summarises the result of an import
+type ImportReport struct {
+	// Imported is the number of events written
+	Imported int `json:"imported"`
+	// Skipped is the number of ephemeral events, and replaceable events for which a newer version is stored
+	Skipped int `json:"skipped"`
+	// Duplicates is the number of events already stored, or repeated in the input
+	Duplicates int `json:"duplicates"`
+	// Invalid is the number of lines that are not a valid and correctly signed event
+	Invalid int `json:"invalid"`
+}
+
+type Service interface {
+	Accept(ctx context.Context, e Event) error
+	Query(ctx context.Context, filter Filter, fn func(Event) error) error
+	Follow(ctx context.Context, source FeedSource, fn func(Event) error) error
+	Export(ctx context.Context, filter Filter, w io.Writer) (int, error)
+	Import(ctx context.Context, r io.Reader) (ImportReport, error)
+}
+
+type service struct {
+	repo         Repository
+	policy       Policy
+	batchSize    int
+	pollInterval time.Duration
+}
+
+func NewService(opts ...func(svc *service)) Service {
+	svc := &service{
+		batchSize:    defaultBatchSize,
+		pollInterval: defaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
+}
+
+func WithRepo(repo Repository) func(svc *service) {
+	return func(svc *service) {
+		svc.repo = repo
+	}
+}
+
+// WithPolicy sets the write policy that Accept checks events against
+func WithPolicy(policy Policy) func(svc *service) {
+	return func(svc *service) {
+		svc.policy = policy
+	}
+}
+
+// WithBatchSize sets the number of events written per bulk write during import
+func WithBatchSize(size int) func(svc *service) {
+	return func(svc *service) {
+		if size > 0 {
+			svc.batchSize = size
+		}
+	}
+}
+
+// Accept verifies the event, checks it against the policy, and stores it unless it is ephemeral.
+// It returns ErrDuplicate or ErrOutdated when the event was not stored because
+// it, or a newer version of it, is already stored.
+func (s *service) Accept(ctx context.Context, e Event) error {
+	if err := e.Verify(); err != nil {
+		return err
+	}
+	if s.policy != nil {
+		if err := s.policy.Check(ctx, e); err != nil {
+			return err
+		}
+	}
+	if e.IsEphemeral() {
+		return nil
+	}
+	outcomes, err := s.repo.bulkWrite(ctx, []Event{e})
+	if err != nil {
+		return err
+	}
+	if outcomes[0] == conflicted {
+		stored, err := s.storedReplaceables(ctx, []Event{e}, outcomes)
+		if err != nil {
+			return err
+		}
+		if e.ReplaceKey() != "" && !stored[e.ID] {
+			return ErrOutdated
+		}
+		return ErrDuplicate
+	}
+	return nil
+}
+
+// storedReplaceables returns the ids of the conflicting replaceable events that
+// are stored themselves, as a conflict does not tell the id and the replace key apart
+func (s *service) storedReplaceables(ctx context.Context, batch []Event, outcomes []writeOutcome) (map[string]bool, error) {
+	var ids []string
+	for i, outcome := range outcomes {
+		if outcome == conflicted && batch[i].ReplaceKey() != "" {
+			ids = append(ids, batch[i].ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	stored := map[string]bool{}
+	err := s.repo.query(ctx, Filter{IDs: ids}, func(e Event) error {
+		stored[e.ID] = true
+		return nil
+	})
+	return stored, err
+}
+
+// Query calls fn for each stored event matching the filter, oldest first, or
+// newest first when the filter has a limit, as NIP-01 asks for the latest events
+func (s *service) Query(ctx context.Context, filter Filter, fn func(Event) error) error {
+	return s.repo.query(ctx, filter, fn)
+}
+
+// Export writes the events matching the filter as JSONL, and returns the number of events written
+func (s *service) Export(ctx context.Context, filter Filter, w io.Writer) (int, error) {
+	count := 0
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err := s.repo.query(ctx, filter, func(e Event) error {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Import reads events as JSONL, verifies them, and stores them in batches
+func (s *service) Import(ctx context.Context, r io.Reader) (ImportReport, error) {
+	var report ImportReport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	batch := make([]Event, 0, s.batchSize)
+	inBatch := map[string]bool{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		outcomes, err := s.repo.bulkWrite(ctx, batch)
+		if err != nil {
+			return err
+		}
+		stored, err := s.storedReplaceables(ctx, batch, outcomes)
+		if err != nil {
+			return err
+		}
+		for i, outcome := range outcomes {
+			switch {
+			case outcome == written:
+				report.Imported++
+			case batch[i].ReplaceKey() != "" && !stored[batch[i].ID]:
+				report.Skipped++
+			default:
+				report.Duplicates++
+			}
+		}
+		batch = batch[:0]
+		inBatch = map[string]bool{}
+		return nil
+	}
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.WithField("line", line).WithError(err).Warn("invalid event json")
+			report.Invalid++
+			continue
+		}
+		if err := e.Verify(); err != nil {
+			log.WithField("line", line).WithField("id", e.ID).WithError(err).Warn("invalid event")
+			report.Invalid++
+			continue
+		}
+		if e.IsEphemeral() {
+			report.Skipped++
+			continue
+		}
+		if inBatch[e.ID] {
+			report.Duplicates++
+			continue
+		}
+		batch = append(batch, e)
+		inBatch[e.ID] = true
+		if len(batch) >= s.batchSize {
+			if err := flush(); err != nil {
+				return report, fmt.Errorf("failed to write batch ending at line %d: %w", line, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, fmt.Errorf("failed to read line %d: %w", line+1, err)
+	}
+	if err := flush(); err != nil {
+		return report, fmt.Errorf("failed to write final batch: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,262 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestImport(t *testing.T) {
+	key := mustNewKey(t)
+	note := signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "note"})
+	stored := signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "already stored"})
+	oldProfile := signed(t, key, Event{CreatedAt: 100, Kind: 0, Content: "old"})
+	newProfile := signed(t, key, Event{CreatedAt: 200, Kind: 0, Content: "new"})
+	ephemeral := signed(t, key, Event{CreatedAt: 100, Kind: 20001})
+	tampered := signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "original"})
+	tampered.Content = "tampered"
+
+	repo := &fakeRepository{stored: map[string]Event{stored.ID: stored}}
+	repo.write(newProfile)
+	svc := NewService(WithRepo(repo), WithBatchSize(2))
+
+	input := jsonl(t, note, note, stored, oldProfile, ephemeral, tampered, newProfile) + "not json\n\n"
+	report, err := svc.Import(context.Background(), strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "report", report, ImportReport{Imported: 1, Skipped: 2, Duplicates: 3, Invalid: 2})
+	verify.Values(t, "batches", repo.batches, 2)
+	verify.Values(t, "profile", repo.byReplaceKey[newProfile.ReplaceKey()].Content, "new")
+}
+
+func TestExport(t *testing.T) {
+	key := mustNewKey(t)
+	profile := signed(t, key, Event{CreatedAt: 100, Kind: 0, Content: "<b>profile</b>"})
+	note := signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "note"})
+	repo := &fakeRepository{stored: map[string]Event{}}
+	repo.write(profile)
+	repo.write(note)
+	svc := NewService(WithRepo(repo))
+
+	var out bytes.Buffer
+	count, err := svc.Export(context.Background(), Filter{Kinds: []int{0}}, &out)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "count", count, 1)
+	verify.Values(t, "output", out.String(), jsonl(t, profile))
+}
+
+func TestAccept(t *testing.T) {
+	key := mustNewKey(t)
+	stored := signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "stored"})
+	newProfile := signed(t, key, Event{CreatedAt: 200, Kind: 0, Content: "new"})
+	tampered := signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "original"})
+	tampered.Content = "tampered"
+	cases := map[string]struct {
+		event      Event
+		wantErr    error
+		wantStored bool
+	}{
+		"new":                   {event: signed(t, key, Event{CreatedAt: 100, Kind: 1, Content: "new"}), wantStored: true},
+		"duplicate":             {event: stored, wantErr: ErrDuplicate, wantStored: true},
+		"duplicate replaceable": {event: newProfile, wantErr: ErrDuplicate, wantStored: true},
+		"outdated":              {event: signed(t, key, Event{CreatedAt: 100, Kind: 0, Content: "old"}), wantErr: ErrOutdated},
+		"ephemeral":             {event: signed(t, key, Event{CreatedAt: 100, Kind: 20001})},
+		"invalid":               {event: tampered, wantErr: ErrInvalidID},
+		"blocked":               {event: signed(t, key, Event{CreatedAt: 100, Kind: 4}), wantErr: ErrBlocked},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			repo := &fakeRepository{stored: map[string]Event{stored.ID: stored}}
+			repo.write(newProfile)
+			svc := NewService(WithRepo(repo), WithPolicy(kindPolicy{blocked: 4}))
+
+			err := svc.Accept(context.Background(), testCase.event)
+			verify.Values(t, "error", errors.Is(err, testCase.wantErr), true)
+			_, found := repo.stored[testCase.event.ID]
+			verify.Values(t, "stored", found, testCase.wantStored)
+		})
+	}
+}
+
+func TestAcceptReplaceableTie(t *testing.T) {
+	key := mustNewKey(t)
+	lower := signed(t, key, Event{CreatedAt: 100, Kind: 0, Content: "a"})
+	higher := signed(t, key, Event{CreatedAt: 100, Kind: 0, Content: "b"})
+	if lower.ID > higher.ID {
+		lower, higher = higher, lower
+	}
+	cases := map[string]struct {
+		stored  Event
+		event   Event
+		wantErr error
+	}{
+		"lower id replaces":     {stored: higher, event: lower},
+		"higher id is outdated": {stored: lower, event: higher, wantErr: ErrOutdated},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			repo := &fakeRepository{stored: map[string]Event{}}
+			repo.write(testCase.stored)
+			svc := NewService(WithRepo(repo))
+
+			err := svc.Accept(context.Background(), testCase.event)
+			verify.Values(t, "error", err, testCase.wantErr)
+			verify.Values(t, "kept", repo.byReplaceKey[lower.ReplaceKey()].ID, lower.ID)
+		})
+	}
+}
+
+func TestFollow(t *testing.T) {
+	before := Event{ID: "before"}
+	after := Event{ID: "after"}
+	errStop := errors.New("stop")
+	unsupported := mongo.CommandError{Code: 40573, Message: "The $changeStream stage is only supported on replica sets"}
+	cases := map[string]struct {
+		source   FeedSource
+		watchErr error
+		watched  []Event
+		want     []Event
+		wantErr  error
+	}{
+		"change stream":                {source: FeedChangeStream, watched: []Event{after}, want: []Event{after}, wantErr: errStop},
+		"change stream not supported":  {source: FeedChangeStream, watchErr: unsupported, wantErr: unsupported},
+		"auto falls back to polling":   {source: FeedAuto, watchErr: unsupported, want: []Event{after}, wantErr: errStop},
+		"polling skips earlier events": {source: FeedPolling, want: []Event{after}, wantErr: errStop},
+		"unknown source":               {source: "other", wantErr: errors.New(`unknown feed source "other"`)},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			repo := &fakeRepository{stored: map[string]Event{}, watchErr: testCase.watchErr, watched: testCase.watched}
+			repo.storeAt(before, time.Now().Add(-time.Second))
+			repo.storeAt(after, time.Now().Add(time.Hour))
+			svc := NewService(WithRepo(repo), WithPollInterval(time.Millisecond))
+
+			var got []Event
+			err := svc.Follow(context.Background(), testCase.source, func(e Event) error {
+				got = append(got, e)
+				return errStop
+			})
+			verify.Values(t, "error", err, testCase.wantErr)
+			verify.Values(t, "events", got, testCase.want)
+		})
+	}
+}
+
+/// Helper Functions ///
+
+func jsonl(t *testing.T, events ...Event) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			t.Fatalf("did not expect error %v", err)
+		}
+	}
+	return b.String()
+}
+
+/// Helper Types ///
+
+// kindPolicy blocks the events of a kind
+type kindPolicy struct {
+	blocked int
+}
+
+func (p kindPolicy) Check(_ context.Context, e Event) error {
+	if e.Kind == p.blocked {
+		return fmt.Errorf("%w: kind %d is not allowed", ErrBlocked, e.Kind)
+	}
+	return nil
+}
+
+type fakeRepository struct {
+	stored       map[string]Event
+	byReplaceKey map[string]Event
+	storedAt     []storedEvent
+	batches      int
+	watchErr     error
+	watched      []Event
+}
+
+func (r *fakeRepository) storeAt(e Event, at time.Time) {
+	r.stored[e.ID] = e
+	r.storedAt = append(r.storedAt, storedEvent{Event: e, StoredAt: at})
+}
+
+func (r *fakeRepository) write(e Event) writeOutcome {
+	if r.byReplaceKey == nil {
+		r.byReplaceKey = map[string]Event{}
+	}
+	if _, found := r.stored[e.ID]; found {
+		return conflicted
+	}
+	if key := e.ReplaceKey(); key != "" {
+		if current, found := r.byReplaceKey[key]; found {
+			if current.CreatedAt > e.CreatedAt || (current.CreatedAt == e.CreatedAt && current.ID <= e.ID) {
+				return conflicted
+			}
+			delete(r.stored, current.ID)
+		}
+		r.byReplaceKey[key] = e
+	}
+	r.storeAt(e, time.Now())
+	return written
+}
+
+func (r *fakeRepository) query(_ context.Context, filter Filter, fn func(Event) error) error {
+	for _, e := range r.stored {
+		if !filter.Matches(e) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) bulkWrite(_ context.Context, batch []Event) ([]writeOutcome, error) {
+	r.batches++
+	outcomes := make([]writeOutcome, len(batch))
+	for i, e := range batch {
+		outcomes[i] = r.write(e)
+	}
+	return outcomes, nil
+}
+
+func (r *fakeRepository) watch(_ context.Context, _ bson.Raw, fn func(Event, bson.Raw) error) error {
+	if r.watchErr != nil {
+		return r.watchErr
+	}
+	for _, e := range r.watched {
+		if err := fn(e, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) storedSince(_ context.Context, since time.Time, fn func(Event, time.Time) error) error {
+	for _, doc := range r.storedAt {
+		if doc.StoredAt.Before(since) {
+			continue
+		}
+		if err := fn(doc.Event, doc.StoredAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
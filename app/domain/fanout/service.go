@@ -0,0 +1,203 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/queue"
+)
+
+const (
+	defaultConcurrency      = 8
+	defaultThrottleAttempts = 4
+	defaultThrottleDelay    = 100 * time.Millisecond
+)
+
+// Poster posts a message to a websocket connection, returning apigateway.ErrGone
+// when the connection was closed, and apigateway.ErrThrottled when it is throttled
+type Poster interface {
+	Post(ctx context.Context, connectionID string, data []byte) error
+}
+
+type Service interface {
+	Broadcast(ctx context.Context, e events.Event) (int, error)
+	Enqueue(ctx context.Context, e events.Event) error
+	HandleQueued(ctx context.Context, event awsevents.SQSEvent) (awsevents.SQSEventResponse, error)
+}
+
+type service struct {
+	subscriptions    subscriptions.Service
+	poster           Poster
+	queue            queue.Queue
+	concurrency      int
+	throttleAttempts int
+	throttleDelay    time.Duration
+}
+
+func NewService(opts ...func(svc *service)) Service {
+	svc := &service{
+		concurrency:      defaultConcurrency,
+		throttleAttempts: defaultThrottleAttempts,
+		throttleDelay:    defaultThrottleDelay,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
+}
+
+func WithSubscriptions(subs subscriptions.Service) func(svc *service) {
+	return func(svc *service) {
+		svc.subscriptions = subs
+	}
+}
+
+func WithPoster(poster Poster) func(svc *service) {
+	return func(svc *service) {
+		svc.poster = poster
+	}
+}
+
+// WithConcurrency sets the number of connections posted to at the same time
+func WithConcurrency(concurrency int) func(svc *service) {
+	return func(svc *service) {
+		if concurrency > 0 {
+			svc.concurrency = concurrency
+		}
+	}
+}
+
+// WithQueue sets the queue that Enqueue puts events on
+func WithQueue(q queue.Queue) func(svc *service) {
+	return func(svc *service) {
+		svc.queue = q
+	}
+}
+
+// WithThrottleRetries sets how often a throttled post is attempted, and the delay
+// before the first retry, which doubles for every next retry
+func WithThrottleRetries(attempts int, delay time.Duration) func(svc *service) {
+	return func(svc *service) {
+		if attempts > 0 {
+			svc.throttleAttempts = attempts
+		}
+		if delay > 0 {
+			svc.throttleDelay = delay
+		}
+	}
+}
+
+// Broadcast posts the event to the subscriptions it matches, and returns the
+// number of subscriptions it was delivered to. The subscriptions of connections
+// that are gone are removed, failures to post to the others are returned joined.
+func (s *service) Broadcast(ctx context.Context, e events.Event) (int, error) {
+	matches, err := s.subscriptions.Matching(ctx, e)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find subscriptions for event %s: %w", e.ID, err)
+	}
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		delivered int
+		errs      []error
+	)
+	slots := make(chan struct{}, s.concurrency)
+	for _, match := range matches {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(match subscriptions.Match) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			ok, err := s.deliver(ctx, match, e)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else if ok {
+				delivered++
+			}
+		}(match)
+	}
+	wg.Wait()
+	return delivered, errors.Join(errs...)
+}
+
+func (s *service) deliver(ctx context.Context, match subscriptions.Match, e events.Event) (bool, error) {
+	msg, err := apigateway.EncodeMessage(apigateway.MessageEvent, match.SubscriptionID, e)
+	if err != nil {
+		return false, err
+	}
+	err = s.post(ctx, match.ConnectionID, msg)
+	if errors.Is(err, apigateway.ErrGone) {
+		log.WithField("connection", match.ConnectionID).Info("connection is gone, removing its subscriptions")
+		return false, s.subscriptions.RemoveConnection(ctx, match.ConnectionID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to post event %s to connection %s: %w", e.ID, match.ConnectionID, err)
+	}
+	return true, nil
+}
+
+// post posts the message to the connection, backing off and retrying while it is throttled
+func (s *service) post(ctx context.Context, connectionID string, msg []byte) error {
+	delay := s.throttleDelay
+	for attempt := 1; ; attempt++ {
+		err := s.poster.Post(ctx, connectionID, msg)
+		if !errors.Is(err, apigateway.ErrThrottled) || attempt >= s.throttleAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay/2 + time.Duration(rand.Int63n(int64(delay)))):
+		}
+		delay *= 2
+	}
+}
+
+// Enqueue puts the event on the queue, for HandleQueued to broadcast it
+func (s *service) Enqueue(ctx context.Context, e events.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.queue.Send(ctx, string(body))
+}
+
+// HandleQueued broadcasts the queued events of an SQS triggered Lambda function.
+// Events that could not be delivered to all their subscriptions are reported as
+// batch item failures, so they are retried, and dead-lettered when that keeps failing.
+// Retrying posts the event again to the subscriptions it was delivered to, which
+// clients ignore as they already have the event.
+func (s *service) HandleQueued(ctx context.Context, event awsevents.SQSEvent) (awsevents.SQSEventResponse, error) {
+	var response awsevents.SQSEventResponse
+	for _, record := range event.Records {
+		var e events.Event
+		if err := json.Unmarshal([]byte(record.Body), &e); err != nil {
+			// retrying would not help, so the message is dropped
+			log.WithField("message", record.MessageId).WithError(err).Error("invalid queued event")
+			continue
+		}
+		delivered, err := s.Broadcast(ctx, e)
+		if err != nil {
+			log.WithField("message", record.MessageId).WithField("id", e.ID).WithError(err).Warn("failed to deliver queued event")
+			response.BatchItemFailures = append(response.BatchItemFailures, awsevents.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+			continue
+		}
+		log.WithField("id", e.ID).WithField("delivered", delivered).Debug("delivered queued event")
+	}
+	return response, nil
+}
@@ -0,0 +1,160 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/queue"
+)
+
+func TestBroadcast(t *testing.T) {
+	e := events.Event{ID: "abc", Kind: 1, Tags: [][]string{}}
+	errPost := errors.New("throttled")
+	cases := map[string]struct {
+		matches       []subscriptions.Match
+		postErrs      map[string]error
+		throttled     int
+		wantDelivered int
+		wantErr       bool
+		wantPosted    []string
+		wantRemoved   []string
+	}{
+		"delivers to all matches": {
+			matches:       []subscriptions.Match{{ConnectionID: "con1", SubscriptionID: "a"}, {ConnectionID: "con2", SubscriptionID: "b"}},
+			wantDelivered: 2,
+			wantPosted:    []string{`con1 ["EVENT","a",{"id":"abc","pubkey":"","created_at":0,"kind":1,"tags":[],"content":"","sig":""}]`, `con2 ["EVENT","b",{"id":"abc","pubkey":"","created_at":0,"kind":1,"tags":[],"content":"","sig":""}]`},
+		},
+		"removes gone connections": {
+			matches:       []subscriptions.Match{{ConnectionID: "con1", SubscriptionID: "a"}, {ConnectionID: "gone", SubscriptionID: "b"}},
+			postErrs:      map[string]error{"gone": apigateway.ErrGone},
+			wantDelivered: 1,
+			wantPosted:    []string{`con1 ["EVENT","a",{"id":"abc","pubkey":"","created_at":0,"kind":1,"tags":[],"content":"","sig":""}]`},
+			wantRemoved:   []string{"gone"},
+		},
+		"continues after failures": {
+			matches:       []subscriptions.Match{{ConnectionID: "con1", SubscriptionID: "a"}, {ConnectionID: "con2", SubscriptionID: "b"}},
+			postErrs:      map[string]error{"con1": errPost},
+			wantDelivered: 1,
+			wantErr:       true,
+			wantPosted:    []string{`con2 ["EVENT","b",{"id":"abc","pubkey":"","created_at":0,"kind":1,"tags":[],"content":"","sig":""}]`},
+		},
+		"retries throttled posts": {
+			matches:       []subscriptions.Match{{ConnectionID: "con1", SubscriptionID: "a"}},
+			postErrs:      map[string]error{"con1": apigateway.ErrThrottled},
+			throttled:     2,
+			wantDelivered: 1,
+			wantPosted:    []string{`con1 ["EVENT","a",{"id":"abc","pubkey":"","created_at":0,"kind":1,"tags":[],"content":"","sig":""}]`},
+		},
+		"gives up when throttled too often": {
+			matches:  []subscriptions.Match{{ConnectionID: "con1", SubscriptionID: "a"}},
+			postErrs: map[string]error{"con1": apigateway.ErrThrottled},
+			// the default of 4 attempts
+			throttled: 4,
+			wantErr:   true,
+		},
+		"no matches": {},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			subs := &fakeSubscriptions{matches: testCase.matches}
+			poster := &fakePoster{errs: testCase.postErrs, failures: testCase.throttled}
+			svc := NewService(WithSubscriptions(subs), WithPoster(poster), WithConcurrency(2), WithThrottleRetries(0, time.Millisecond))
+
+			delivered, err := svc.Broadcast(context.Background(), e)
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			verify.Values(t, "delivered", delivered, testCase.wantDelivered)
+			sort.Strings(poster.posted)
+			verify.Values(t, "posted", poster.posted, testCase.wantPosted)
+			verify.Values(t, "removed", subs.removed, testCase.wantRemoved)
+		})
+	}
+}
+
+func TestQueuedDelivery(t *testing.T) {
+	q := queue.NewMemoryQueue(3)
+	subs := &fakeSubscriptions{matches: []subscriptions.Match{{ConnectionID: "con1", SubscriptionID: "a"}}}
+	poster := &fakePoster{errs: map[string]error{"con1": apigateway.ErrThrottled}, failures: 1}
+	svc := NewService(WithSubscriptions(subs), WithPoster(poster), WithQueue(q), WithThrottleRetries(1, time.Millisecond))
+	ctx := context.Background()
+
+	delivered := events.Event{ID: "delivered", Tags: [][]string{}}
+	undeliverable := events.Event{ID: "undeliverable", Tags: [][]string{}}
+
+	// an event that is throttled once is delivered when it is received again
+	if err := svc.Enqueue(ctx, delivered); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	if err := q.Drain(ctx, 1, svc.HandleQueued); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "posted", poster.posted, []string{
+		`con1 ["EVENT","a",{"id":"delivered","pubkey":"","created_at":0,"kind":0,"tags":[],"content":"","sig":""}]`,
+	})
+	verify.Values(t, "dead letters", q.DeadLetters(), []string(nil))
+
+	// an event that keeps being throttled ends up in the dead-letter queue
+	poster.failures = 10
+	poster.posted = nil
+	if err := svc.Enqueue(ctx, undeliverable); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	if err := q.Drain(ctx, 1, svc.HandleQueued); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	body, _ := json.Marshal(undeliverable)
+	verify.Values(t, "posted", poster.posted, []string(nil))
+	verify.Values(t, "dead letters", q.DeadLetters(), []string{string(body)})
+}
+
+/// Helper Types ///
+
+type fakeSubscriptions struct {
+	subscriptions.Service
+	mu      sync.Mutex
+	matches []subscriptions.Match
+	removed []string
+}
+
+func (s *fakeSubscriptions) Matching(context.Context, events.Event) ([]subscriptions.Match, error) {
+	return s.matches, nil
+}
+
+func (s *fakeSubscriptions) RemoveConnection(_ context.Context, connectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = append(s.removed, connectionID)
+	return nil
+}
+
+// fakePoster fails posting to the connections in errs, the first failures times
+// when failures is set, or else always
+type fakePoster struct {
+	mu       sync.Mutex
+	errs     map[string]error
+	failures int
+	posted   []string
+}
+
+func (p *fakePoster) Post(_ context.Context, connectionID string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.errs[connectionID]; err != nil && p.failures >= 0 {
+		if p.failures--; p.failures == 0 {
+			p.failures = -1
+		}
+		return fmt.Errorf("%w: %s", err, connectionID)
+	}
+	p.posted = append(p.posted, connectionID+" "+string(data))
+	return nil
+}
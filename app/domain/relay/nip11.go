@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"context"
+)
+
+// ContentTypeInfo is the content type of the relay information document, see NIP-11
+const ContentTypeInfo = "application/nostr+json"
+
+// Document is the relay information document of NIP-11
+type Document struct {
+	Name          string     `json:"name,omitempty"`
+	Description   string     `json:"description,omitempty"`
+	Icon          string     `json:"icon,omitempty"`
+	Pubkey        string     `json:"pubkey,omitempty"`
+	Contact       string     `json:"contact,omitempty"`
+	SupportedNIPs []int      `json:"supported_nips"`
+	Software      string     `json:"software,omitempty"`
+	Version       string     `json:"version,omitempty"`
+	Limitation    Limitation `json:"limitation"`
+}
+
+// Limitation is the limitation section of the relay information document
+type Limitation struct {
+	MaxMessageLength int  `json:"max_message_length,omitempty"`
+	MaxLimit         int  `json:"max_limit,omitempty"`
+	RestrictedWrites bool `json:"restricted_writes"`
+}
+
+// Document returns the relay information document, with the info and the
+// restrictions that were set through the management API
+func (s *service) Document(ctx context.Context) (Document, error) {
+	document := s.document
+	info, err := s.repo.info(ctx)
+	if err != nil {
+		return document, err
+	}
+	if info.Name != "" {
+		document.Name = info.Name
+	}
+	if info.Description != "" {
+		document.Description = info.Description
+	}
+	if info.Icon != "" {
+		document.Icon = info.Icon
+	}
+	rules, err := s.ruleSet(ctx)
+	if err != nil {
+		return document, err
+	}
+	// banned pubkeys and events do not restrict writes, an allowlist and banned kinds do
+	document.Limitation.RestrictedWrites = s.allowlist.Pubkeys || s.allowlist.Kinds || rules.banned[SubjectKind] > 0
+	return document, nil
+}
@@ -0,0 +1,205 @@
+package relay
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+// ContentTypeRPC is the content type of the management requests and responses, see NIP-86
+const ContentTypeRPC = "application/nostr+json+rpc"
+
+// errInvalidParams is returned by methods called with missing or malformed params
+var errInvalidParams = errkind.New(errkind.Validation, "invalid params")
+
+// Request is a NIP-86 management request
+type Request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// Response is a NIP-86 management response, with an error message or a result
+type Response struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// pubkeyEntry, eventEntry and ipEntry are the entries of the list methods
+type pubkeyEntry struct {
+	Pubkey string `json:"pubkey"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type eventEntry struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ipEntry struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type method func(s *service, ctx context.Context, params []json.RawMessage) (interface{}, error)
+
+// methodSupportedMethods lists the supported methods, including itself
+const methodSupportedMethods = "supportedmethods"
+
+// methods are the supported management methods, next to supportedmethods.
+// allowpubkey and allowkind replace a ban, and only block the values that are
+// not allowed when the service has an Allowlist for pubkeys or kinds.
+var methods = map[string]method{
+	"banpubkey":          setRule(SubjectPubkey, StatusBanned, hexParam),
+	"allowpubkey":        setRule(SubjectPubkey, StatusAllowed, hexParam),
+	"listbannedpubkeys":  listRules(SubjectPubkey, StatusBanned, func(r Rule) interface{} { return pubkeyEntry{r.Value, r.Reason} }),
+	"listallowedpubkeys": listRules(SubjectPubkey, StatusAllowed, func(r Rule) interface{} { return pubkeyEntry{r.Value, r.Reason} }),
+	"banevent":           setRule(SubjectEvent, StatusBanned, hexParam),
+	"allowevent":         removeRule(SubjectEvent, hexParam),
+	"listbannedevents":   listRules(SubjectEvent, StatusBanned, func(r Rule) interface{} { return eventEntry{r.Value, r.Reason} }),
+	"allowkind":          setRule(SubjectKind, StatusAllowed, kindParam),
+	"disallowkind":       setRule(SubjectKind, StatusBanned, kindParam),
+	"listallowedkinds": listRules(SubjectKind, StatusAllowed, func(r Rule) interface{} {
+		kind, _ := strconv.Atoi(r.Value)
+		return kind
+	}),
+	"blockip":                setRule(SubjectIP, StatusBanned, ipParam),
+	"unblockip":              removeRule(SubjectIP, ipParam),
+	"listblockedips":         listRules(SubjectIP, StatusBanned, func(r Rule) interface{} { return ipEntry{r.Value, r.Reason} }),
+	"changerelayname":        changeInfo(func(info *Info, value string) { info.Name = value }),
+	"changerelaydescription": changeInfo(func(info *Info, value string) { info.Description = value }),
+	"changerelayicon":        changeInfo(func(info *Info, value string) { info.Icon = value }),
+}
+
+// Manage executes the management request
+func (s *service) Manage(ctx context.Context, request Request) Response {
+	if request.Method == methodSupportedMethods {
+		names := []string{methodSupportedMethods}
+		for name := range methods {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Response{Result: names}
+	}
+	m, found := methods[request.Method]
+	if !found {
+		return Response{Error: fmt.Sprintf("unsupported method %q", request.Method)}
+	}
+	result, err := m(s, ctx, request.Params)
+	if errors.Is(err, errInvalidParams) {
+		return Response{Error: err.Error()}
+	}
+	if err != nil {
+		log.WithError(err).WithField("method", request.Method).Error("management request failed")
+		return Response{Error: "error: could not execute " + request.Method}
+	}
+	return Response{Result: result}
+}
+
+// paramParser returns the value of a rule from the first param
+type paramParser func(params []json.RawMessage) (string, error)
+
+func setRule(subject Subject, status Status, parse paramParser) method {
+	return func(s *service, ctx context.Context, params []json.RawMessage) (interface{}, error) {
+		value, err := parse(params)
+		if err != nil {
+			return nil, err
+		}
+		reason, err := optionalStringParam(params, 1)
+		if err != nil {
+			return nil, err
+		}
+		return true, s.SetRule(ctx, Rule{Subject: subject, Value: value, Status: status, Reason: reason})
+	}
+}
+
+func removeRule(subject Subject, parse paramParser) method {
+	return func(s *service, ctx context.Context, params []json.RawMessage) (interface{}, error) {
+		value, err := parse(params)
+		if err != nil {
+			return nil, err
+		}
+		return true, s.RemoveRule(ctx, subject, value)
+	}
+}
+
+func listRules(subject Subject, status Status, entry func(Rule) interface{}) method {
+	return func(s *service, ctx context.Context, _ []json.RawMessage) (interface{}, error) {
+		rules, err := s.Rules(ctx, subject, status)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]interface{}, len(rules))
+		for i, rule := range rules {
+			entries[i] = entry(rule)
+		}
+		return entries, nil
+	}
+}
+
+func changeInfo(set func(info *Info, value string)) method {
+	return func(s *service, ctx context.Context, params []json.RawMessage) (interface{}, error) {
+		value, err := optionalStringParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, fmt.Errorf("%w: expected a value", errInvalidParams)
+		}
+		var info Info
+		set(&info, value)
+		return true, s.SetInfo(ctx, info)
+	}
+}
+
+// hexParam parses a pubkey or event id, which are 32 bytes in lowercase hex
+func hexParam(params []json.RawMessage) (string, error) {
+	value, err := optionalStringParam(params, 0)
+	if err != nil {
+		return "", err
+	}
+	if b, err := hex.DecodeString(value); err != nil || len(b) != 32 || hex.EncodeToString(b) != value {
+		return "", fmt.Errorf("%w: expected 64 lowercase hex characters", errInvalidParams)
+	}
+	return value, nil
+}
+
+func kindParam(params []json.RawMessage) (string, error) {
+	var kind int
+	if len(params) == 0 || json.Unmarshal(params[0], &kind) != nil || kind < 0 {
+		return "", fmt.Errorf("%w: expected a kind", errInvalidParams)
+	}
+	return strconv.Itoa(kind), nil
+}
+
+func ipParam(params []json.RawMessage) (string, error) {
+	value, err := optionalStringParam(params, 0)
+	if err != nil {
+		return "", err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: expected an IP address", errInvalidParams)
+	}
+	return addr.String(), nil
+}
+
+// optionalStringParam returns the string param at index i, or an empty string when there is none
+func optionalStringParam(params []json.RawMessage, i int) (string, error) {
+	if i >= len(params) {
+		return "", nil
+	}
+	var value string
+	if err := json.Unmarshal(params[i], &value); err != nil {
+		return "", fmt.Errorf("%w: expected a string at %d", errInvalidParams, i)
+	}
+	return value, nil
+}
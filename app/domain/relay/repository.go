@@ -0,0 +1,109 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+const (
+	rulesCollectionName = "relay_rules"
+	infoCollectionName  = "relay_info"
+	// infoID is the id of the single info document
+	infoID = "relay"
+)
+
+// Subject is what a rule is about
+type Subject string
+
+const (
+	SubjectPubkey Subject = "pubkey"
+	SubjectEvent  Subject = "event"
+	SubjectKind   Subject = "kind"
+	SubjectIP     Subject = "ip"
+)
+
+// Status is what a rule does with its subject
+type Status string
+
+const (
+	StatusAllowed Status = "allowed"
+	StatusBanned  Status = "banned"
+)
+
+// Rule allows or bans a pubkey, event id, kind or IP address. There is at most
+// one rule per subject and value, so banning a pubkey replaces allowing it.
+type Rule struct {
+	Subject   Subject   `json:"subject" bson:"subject"`
+	Value     string    `json:"value" bson:"value"`
+	Status    Status    `json:"status" bson:"status"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Info is the relay information changed through the management API, empty fields are not changed
+type Info struct {
+	Name        string `json:"name,omitempty" bson:"name,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	Icon        string `json:"icon,omitempty" bson:"icon,omitempty"`
+}
+
+type infoDocument struct {
+	ID   string `bson:"_id"`
+	Info `bson:",inline"`
+}
+
+type Repository interface {
+	putRule(ctx context.Context, rule Rule) error
+	removeRule(ctx context.Context, subject Subject, value string) error
+	rules(ctx context.Context) ([]Rule, error)
+	// setInfo sets the info field, named by its bson name
+	setInfo(ctx context.Context, field, value string) error
+	info(ctx context.Context) (Info, error)
+}
+
+type repository struct {
+	rulesColl skmongo.TypedCollection[Rule]
+	infoColl  skmongo.TypedCollection[infoDocument]
+}
+
+func MustNewRepository(secret string) Repository {
+	return NewRepository(skmongo.MustFromSecret(secret))
+}
+
+func NewRepository(db skmongo.CollectionProvider) Repository {
+	return &repository{
+		rulesColl: skmongo.NewTypedCollection[Rule](db, rulesCollectionName),
+		infoColl:  skmongo.NewTypedCollection[infoDocument](db, infoCollectionName),
+	}
+}
+
+func (r *repository) putRule(ctx context.Context, rule Rule) error {
+	return r.rulesColl.Upsert(ctx, bson.M{"subject": rule.Subject, "value": rule.Value}, rule)
+}
+
+func (r *repository) removeRule(ctx context.Context, subject Subject, value string) error {
+	_, err := r.rulesColl.DeleteMany(ctx, bson.M{"subject": subject, "value": value})
+	return err
+}
+
+func (r *repository) rules(ctx context.Context) ([]Rule, error) {
+	return r.rulesColl.FindAll(ctx, bson.M{})
+}
+
+func (r *repository) setInfo(ctx context.Context, field, value string) error {
+	return r.infoColl.UpdateOne(ctx, bson.M{"_id": infoID}, bson.M{"$set": bson.M{field: value}}, options.Update().SetUpsert(true))
+}
+
+func (r *repository) info(ctx context.Context) (Info, error) {
+	doc, err := r.infoColl.FindOne(ctx, bson.M{"_id": infoID})
+	if errors.Is(err, skmongo.ErrNotFound) {
+		return Info{}, nil
+	}
+	return doc.Info, err
+}
@@ -0,0 +1,204 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+)
+
+// defaultCacheTTL is how long the rules are cached, so rules changed by another function apply after at most this long
+const defaultCacheTTL = 30 * time.Second
+
+type Service interface {
+	// Check implements events.Policy with the rules
+	Check(ctx context.Context, e events.Event) error
+	IsBlockedIP(ctx context.Context, ip string) (bool, error)
+	SetRule(ctx context.Context, rule Rule) error
+	RemoveRule(ctx context.Context, subject Subject, value string) error
+	Rules(ctx context.Context, subject Subject, status Status) ([]Rule, error)
+	SetInfo(ctx context.Context, info Info) error
+	Document(ctx context.Context) (Document, error)
+	Manage(ctx context.Context, request Request) Response
+}
+
+// Allowlist selects the subjects of which only the allowed values are accepted.
+// Without it, allowing a pubkey or kind only clears its ban, so allowing one
+// pubkey does not block all others.
+type Allowlist struct {
+	Pubkeys bool
+	Kinds   bool
+}
+
+type service struct {
+	repo      Repository
+	document  Document
+	allowlist Allowlist
+	cacheTTL  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	cached   *ruleSet
+	loadedAt time.Time
+}
+
+func NewService(opts ...func(svc *service)) Service {
+	svc := &service{
+		cacheTTL: defaultCacheTTL,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
+}
+
+func WithRepo(repo Repository) func(svc *service) {
+	return func(svc *service) {
+		svc.repo = repo
+	}
+}
+
+// WithDocument sets the relay information document, of which the name,
+// description and icon can be changed through the management API
+func WithDocument(document Document) func(svc *service) {
+	return func(svc *service) {
+		svc.document = document
+	}
+}
+
+// WithAllowlist sets the subjects of which only the allowed values are accepted
+func WithAllowlist(allowlist Allowlist) func(svc *service) {
+	return func(svc *service) {
+		svc.allowlist = allowlist
+	}
+}
+
+// WithCacheTTL sets how long the rules are cached
+func WithCacheTTL(ttl time.Duration) func(svc *service) {
+	return func(svc *service) {
+		svc.cacheTTL = ttl
+	}
+}
+
+// Check returns an error wrapping events.ErrBlocked when the event, its pubkey or its kind is banned,
+// or when the allowlist has pubkeys or kinds and the event's are not allowed
+func (s *service) Check(ctx context.Context, e events.Event) error {
+	rules, err := s.ruleSet(ctx)
+	if err != nil {
+		return err
+	}
+	kind := strconv.Itoa(e.Kind)
+	switch {
+	case rules.is(SubjectEvent, e.ID, StatusBanned):
+		return fmt.Errorf("%w: event is banned", events.ErrBlocked)
+	case rules.is(SubjectPubkey, e.PubKey, StatusBanned):
+		return fmt.Errorf("%w: pubkey is banned", events.ErrBlocked)
+	case s.allowlist.Pubkeys && !rules.is(SubjectPubkey, e.PubKey, StatusAllowed):
+		return fmt.Errorf("%w: pubkey is not allowed", events.ErrBlocked)
+	case rules.is(SubjectKind, kind, StatusBanned),
+		s.allowlist.Kinds && !rules.is(SubjectKind, kind, StatusAllowed):
+		return fmt.Errorf("%w: kind %d is not allowed", events.ErrBlocked, e.Kind)
+	}
+	return nil
+}
+
+// IsBlockedIP tells if connections from the IP address are refused
+func (s *service) IsBlockedIP(ctx context.Context, ip string) (bool, error) {
+	rules, err := s.ruleSet(ctx)
+	if err != nil {
+		return false, err
+	}
+	return rules.is(SubjectIP, ip, StatusBanned), nil
+}
+
+func (s *service) SetRule(ctx context.Context, rule Rule) error {
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = s.now()
+	}
+	defer s.invalidate()
+	return s.repo.putRule(ctx, rule)
+}
+
+func (s *service) RemoveRule(ctx context.Context, subject Subject, value string) error {
+	defer s.invalidate()
+	return s.repo.removeRule(ctx, subject, value)
+}
+
+// Rules returns the rules with the subject and status, as stored and not cached
+func (s *service) Rules(ctx context.Context, subject Subject, status Status) ([]Rule, error) {
+	all, err := s.repo.rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for _, rule := range all {
+		if rule.Subject == subject && rule.Status == status {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// SetInfo changes the non-empty fields of the info
+func (s *service) SetInfo(ctx context.Context, info Info) error {
+	for field, value := range map[string]string{"name": info.Name, "description": info.Description, "icon": info.Icon} {
+		if value == "" {
+			continue
+		}
+		if err := s.repo.setInfo(ctx, field, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleSet returns the cached rules, loading them when they expired
+func (s *service) ruleSet(ctx context.Context) (*ruleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && s.now().Sub(s.loadedAt) < s.cacheTTL {
+		return s.cached, nil
+	}
+	rules, err := s.repo.rules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load relay rules: %w", err)
+	}
+	s.cached = newRuleSet(rules)
+	s.loadedAt = s.now()
+	return s.cached, nil
+}
+
+func (s *service) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cached = nil
+}
+
+// ruleSet is the status of each subject and value, for quick checks
+type ruleSet struct {
+	statuses map[Subject]map[string]Status
+	// banned is the number of banned values per subject
+	banned map[Subject]int
+}
+
+func newRuleSet(rules []Rule) *ruleSet {
+	set := &ruleSet{statuses: map[Subject]map[string]Status{}, banned: map[Subject]int{}}
+	for _, rule := range rules {
+		if set.statuses[rule.Subject] == nil {
+			set.statuses[rule.Subject] = map[string]Status{}
+		}
+		set.statuses[rule.Subject][rule.Value] = rule.Status
+		if rule.Status == StatusBanned {
+			set.banned[rule.Subject]++
+		}
+	}
+	return set
+}
+
+func (r *ruleSet) is(subject Subject, value string, status Status) bool {
+	return r.statuses[subject][value] == status
+}
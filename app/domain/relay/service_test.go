@@ -0,0 +1,235 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+)
+
+var (
+	pubkey1 = strings.Repeat("1", 64)
+	pubkey2 = strings.Repeat("2", 64)
+	eventID = strings.Repeat("e", 64)
+)
+
+func TestCheck(t *testing.T) {
+	cases := map[string]struct {
+		rules     []Rule
+		allowlist Allowlist
+		event     events.Event
+		wantErr   error
+	}{
+		"no rules": {
+			event: events.Event{ID: eventID, PubKey: pubkey1, Kind: 1},
+		},
+		"banned pubkey": {
+			rules:   []Rule{{Subject: SubjectPubkey, Value: pubkey1, Status: StatusBanned}},
+			event:   events.Event{ID: eventID, PubKey: pubkey1, Kind: 1},
+			wantErr: events.ErrBlocked,
+		},
+		"banned event": {
+			rules:   []Rule{{Subject: SubjectEvent, Value: eventID, Status: StatusBanned}},
+			event:   events.Event{ID: eventID, PubKey: pubkey1, Kind: 1},
+			wantErr: events.ErrBlocked,
+		},
+		"allowed pubkey": {
+			rules: []Rule{{Subject: SubjectPubkey, Value: pubkey1, Status: StatusAllowed}},
+			event: events.Event{ID: eventID, PubKey: pubkey1, Kind: 1},
+		},
+		"allowed pubkey clears its ban": {
+			rules: []Rule{
+				{Subject: SubjectPubkey, Value: pubkey1, Status: StatusBanned},
+				{Subject: SubjectPubkey, Value: pubkey1, Status: StatusAllowed},
+			},
+			event: events.Event{ID: eventID, PubKey: pubkey1, Kind: 1},
+		},
+		"other pubkeys without an allowlist": {
+			rules: []Rule{{Subject: SubjectPubkey, Value: pubkey1, Status: StatusAllowed}},
+			event: events.Event{ID: eventID, PubKey: pubkey2, Kind: 1},
+		},
+		"pubkey that is not allowed": {
+			rules:     []Rule{{Subject: SubjectPubkey, Value: pubkey1, Status: StatusAllowed}},
+			allowlist: Allowlist{Pubkeys: true},
+			event:     events.Event{ID: eventID, PubKey: pubkey2, Kind: 1},
+			wantErr:   events.ErrBlocked,
+		},
+		"allowlist without allowed pubkeys": {
+			allowlist: Allowlist{Pubkeys: true},
+			event:     events.Event{ID: eventID, PubKey: pubkey1, Kind: 1},
+			wantErr:   events.ErrBlocked,
+		},
+		"other kinds without an allowlist": {
+			rules: []Rule{{Subject: SubjectKind, Value: "1", Status: StatusAllowed}},
+			event: events.Event{ID: eventID, PubKey: pubkey1, Kind: 4},
+		},
+		"kind that is not allowed": {
+			rules:     []Rule{{Subject: SubjectKind, Value: "1", Status: StatusAllowed}},
+			allowlist: Allowlist{Kinds: true},
+			event:     events.Event{ID: eventID, PubKey: pubkey1, Kind: 4},
+			wantErr:   events.ErrBlocked,
+		},
+		"disallowed kind": {
+			rules:   []Rule{{Subject: SubjectKind, Value: "4", Status: StatusBanned}},
+			event:   events.Event{ID: eventID, PubKey: pubkey1, Kind: 4},
+			wantErr: events.ErrBlocked,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			repo := &fakeRepository{}
+			svc := NewService(WithRepo(repo), WithAllowlist(testCase.allowlist))
+			for _, rule := range testCase.rules {
+				if err := svc.SetRule(context.Background(), rule); err != nil {
+					t.Fatalf("did not expect error %v", err)
+				}
+			}
+			err := svc.Check(context.Background(), testCase.event)
+			verify.Values(t, "error", errors.Is(err, testCase.wantErr), true)
+		})
+	}
+}
+
+func TestManage(t *testing.T) {
+	svc := NewService(WithRepo(&fakeRepository{}), WithDocument(Document{Name: "relay", SupportedNIPs: []int{1, 11, 86}}))
+	ctx := context.Background()
+	steps := []struct {
+		request string
+		want    string
+	}{
+		{`{"method":"banpubkey","params":["` + pubkey1 + `","spam"]}`, `{"result":true}`},
+		{`{"method":"allowpubkey","params":["` + pubkey2 + `"]}`, `{"result":true}`},
+		{`{"method":"listbannedpubkeys","params":[]}`, `{"result":[{"pubkey":"` + pubkey1 + `","reason":"spam"}]}`},
+		{`{"method":"allowpubkey","params":["` + pubkey1 + `"]}`, `{"result":true}`},
+		{`{"method":"listbannedpubkeys","params":[]}`, `{"result":[]}`},
+		{`{"method":"banpubkey","params":["npub"]}`, `{"result":null,"error":"invalid params: expected 64 lowercase hex characters"}`},
+		{`{"method":"allowkind","params":[1]}`, `{"result":true}`},
+		{`{"method":"listallowedkinds","params":[]}`, `{"result":[1]}`},
+		{`{"method":"blockip","params":["192.0.2.1","abuse"]}`, `{"result":true}`},
+		{`{"method":"listblockedips","params":[]}`, `{"result":[{"ip":"192.0.2.1","reason":"abuse"}]}`},
+		{`{"method":"changerelayname","params":["renamed"]}`, `{"result":true}`},
+		{`{"method":"deleteeverything","params":[]}`, `{"result":null,"error":"unsupported method \"deleteeverything\""}`},
+	}
+	for _, step := range steps {
+		var request Request
+		if err := json.Unmarshal([]byte(step.request), &request); err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(svc.Manage(ctx, request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, request.Method, string(got), step.want)
+	}
+
+	blocked, err := svc.IsBlockedIP(ctx, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "blocked ip", blocked, true)
+	document, err := svc.Document(ctx)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "document", document, Document{
+		Name:          "renamed",
+		SupportedNIPs: []int{1, 11, 86},
+	})
+}
+
+func TestDocumentRestrictedWrites(t *testing.T) {
+	cases := map[string]struct {
+		rules     []Rule
+		allowlist Allowlist
+		want      bool
+	}{
+		"no rules": {},
+		"allowed and banned pubkeys": {
+			rules: []Rule{
+				{Subject: SubjectPubkey, Value: pubkey1, Status: StatusAllowed},
+				{Subject: SubjectPubkey, Value: pubkey2, Status: StatusBanned},
+			},
+		},
+		"allowed kinds": {
+			rules: []Rule{{Subject: SubjectKind, Value: "1", Status: StatusAllowed}},
+		},
+		"banned kinds": {
+			rules: []Rule{{Subject: SubjectKind, Value: "4", Status: StatusBanned}},
+			want:  true,
+		},
+		"pubkey allowlist": {
+			allowlist: Allowlist{Pubkeys: true},
+			want:      true,
+		},
+		"kind allowlist": {
+			allowlist: Allowlist{Kinds: true},
+			want:      true,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			svc := NewService(WithRepo(&fakeRepository{stored: testCase.rules}), WithAllowlist(testCase.allowlist))
+			document, err := svc.Document(context.Background())
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "restricted writes", document.Limitation.RestrictedWrites, testCase.want)
+		})
+	}
+}
+
+func TestSupportedMethods(t *testing.T) {
+	response := NewService(WithRepo(&fakeRepository{})).Manage(context.Background(), Request{Method: "supportedmethods"})
+	names, _ := response.Result.([]string)
+	verify.Values(t, "count", len(names), len(methods)+1)
+	verify.Values(t, "first", names[0], "allowevent")
+}
+
+/// Helper Types ///
+
+type fakeRepository struct {
+	stored     []Rule
+	storedInfo Info
+}
+
+func (r *fakeRepository) putRule(ctx context.Context, rule Rule) error {
+	_ = r.removeRule(ctx, rule.Subject, rule.Value)
+	r.stored = append(r.stored, rule)
+	return nil
+}
+
+func (r *fakeRepository) removeRule(_ context.Context, subject Subject, value string) error {
+	var kept []Rule
+	for _, rule := range r.stored {
+		if rule.Subject != subject || rule.Value != value {
+			kept = append(kept, rule)
+		}
+	}
+	r.stored = kept
+	return nil
+}
+
+func (r *fakeRepository) rules(context.Context) ([]Rule, error) {
+	return r.stored, nil
+}
+
+func (r *fakeRepository) setInfo(_ context.Context, field, value string) error {
+	switch field {
+	case "name":
+		r.storedInfo.Name = value
+	case "description":
+		r.storedInfo.Description = value
+	case "icon":
+		r.storedInfo.Icon = value
+	}
+	return nil
+}
+
+func (r *fakeRepository) info(context.Context) (Info, error) {
+	return r.storedInfo, nil
+}
@@ -0,0 +1,107 @@
+package subscriptions
+
+import (
+	"context"
+	"sync"
+)
+
+type subscriptionKey struct {
+	connectionID   string
+	subscriptionID string
+}
+
+type memoryRepository struct {
+	mu    sync.RWMutex
+	subs  map[subscriptionKey]subscription
+	index map[string]map[subscriptionKey]bool
+}
+
+// NewMemoryRepository creates a repository keeping the subscriptions in memory,
+// indexed on their index keys like the index_keys index of the collection, for
+// a single process relay and for tests
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		subs:  map[subscriptionKey]subscription{},
+		index: map[string]map[subscriptionKey]bool{},
+	}
+}
+
+func (r *memoryRepository) put(_ context.Context, sub subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := subscriptionKey{sub.ConnectionID, sub.SubscriptionID}
+	r.removeLocked(key)
+	r.subs[key] = sub
+	for _, indexKey := range sub.indexKeys() {
+		if r.index[indexKey] == nil {
+			r.index[indexKey] = map[subscriptionKey]bool{}
+		}
+		r.index[indexKey][key] = true
+	}
+	return nil
+}
+
+func (r *memoryRepository) remove(_ context.Context, connectionID, subscriptionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(subscriptionKey{connectionID, subscriptionID})
+	return nil
+}
+
+func (r *memoryRepository) removeConnection(_ context.Context, connectionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key := range r.subs {
+		if key.connectionID == connectionID {
+			r.removeLocked(key)
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) count(_ context.Context, connectionID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := 0
+	for key := range r.subs {
+		if key.connectionID == connectionID {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *memoryRepository) candidates(_ context.Context, keys []string, fn func(subscription) error) error {
+	r.mu.RLock()
+	found := map[subscriptionKey]bool{}
+	var candidates []subscription
+	for _, indexKey := range keys {
+		for key := range r.index[indexKey] {
+			if !found[key] {
+				found[key] = true
+				candidates = append(candidates, r.subs[key])
+			}
+		}
+	}
+	r.mu.RUnlock()
+	for _, sub := range candidates {
+		if err := fn(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) removeLocked(key subscriptionKey) {
+	sub, found := r.subs[key]
+	if !found {
+		return
+	}
+	delete(r.subs, key)
+	for _, indexKey := range sub.indexKeys() {
+		delete(r.index[indexKey], key)
+		if len(r.index[indexKey]) == 0 {
+			delete(r.index, indexKey)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package subscriptions
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+const collectionName = "subscriptions"
+
+type subscription struct {
+	ConnectionID   string          `json:"connection_id" bson:"connection_id"`
+	SubscriptionID string          `json:"subscription_id" bson:"subscription_id"`
+	Filters        []events.Filter `json:"filters" bson:"filters"`
+	// IndexKeys are the index keys of the filters, see events.Filter.IndexKeys
+	IndexKeys []string  `json:"index_keys" bson:"index_keys"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+type Repository interface {
+	put(ctx context.Context, sub subscription) error
+	remove(ctx context.Context, connectionID, subscriptionID string) error
+	removeConnection(ctx context.Context, connectionID string) error
+	count(ctx context.Context, connectionID string) (int, error)
+	// candidates calls fn for each subscription with at least one of the index keys,
+	// and each subscription stored before subscriptions had index keys
+	candidates(ctx context.Context, keys []string, fn func(subscription) error) error
+}
+
+type repository struct {
+	c skmongo.TypedCollection[subscription]
+}
+
+func MustNewRepository(secret string) Repository {
+	return NewRepository(skmongo.MustFromSecret(secret))
+}
+
+func NewRepository(db skmongo.CollectionProvider) Repository {
+	return &repository{
+		c: skmongo.NewTypedCollection[subscription](db, collectionName),
+	}
+}
+
+// put stores the subscription, replacing the one with the same id on the connection as NIP-01 asks
+func (r *repository) put(ctx context.Context, sub subscription) error {
+	return r.c.Upsert(ctx, bson.M{"connection_id": sub.ConnectionID, "subscription_id": sub.SubscriptionID}, sub)
+}
+
+func (r *repository) remove(ctx context.Context, connectionID, subscriptionID string) error {
+	_, err := r.c.DeleteMany(ctx, bson.M{"connection_id": connectionID, "subscription_id": subscriptionID})
+	return err
+}
+
+func (r *repository) removeConnection(ctx context.Context, connectionID string) error {
+	_, err := r.c.DeleteMany(ctx, bson.M{"connection_id": connectionID})
+	return err
+}
+
+func (r *repository) count(ctx context.Context, connectionID string) (int, error) {
+	n, err := r.c.Count(ctx, bson.M{"connection_id": connectionID})
+	return int(n), err
+}
+
+func (r *repository) candidates(ctx context.Context, keys []string, fn func(subscription) error) error {
+	return r.c.Find(ctx, candidatesQuery(keys), fn)
+}
+
+// candidatesQuery matches the subscriptions with one of the keys, and those
+// without index keys, which were stored before migration 0003 added them
+func candidatesQuery(keys []string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"index_keys": bson.M{"$in": keys}},
+		bson.M{"index_keys": bson.M{"$exists": false}},
+	}}
+}
+
+// indexKeys returns the keys of the subscription, a subscription without keys
+// is a candidate for all events like in candidatesQuery
+func (sub subscription) indexKeys() []string {
+	if sub.IndexKeys == nil {
+		return []string{events.MatchAllKey}
+	}
+	return sub.IndexKeys
+}
@@ -0,0 +1,92 @@
+package subscriptions
+
+import (
+	"context"
+	"time"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+)
+
+// Match is a subscription on a connection that an event matches
+type Match struct {
+	ConnectionID   string
+	SubscriptionID string
+}
+
+type Service interface {
+	Subscribe(ctx context.Context, connectionID, subscriptionID string, filters []events.Filter) error
+	Unsubscribe(ctx context.Context, connectionID, subscriptionID string) error
+	RemoveConnection(ctx context.Context, connectionID string) error
+	Count(ctx context.Context, connectionID string) (int, error)
+	Matching(ctx context.Context, e events.Event) ([]Match, error)
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(opts ...func(svc *service)) Service {
+	svc := &service{}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
+}
+
+func WithRepo(repo Repository) func(svc *service) {
+	return func(svc *service) {
+		svc.repo = repo
+	}
+}
+
+func (s *service) Subscribe(ctx context.Context, connectionID, subscriptionID string, filters []events.Filter) error {
+	return s.repo.put(ctx, subscription{
+		ConnectionID:   connectionID,
+		SubscriptionID: subscriptionID,
+		Filters:        filters,
+		IndexKeys:      indexKeys(filters),
+		CreatedAt:      time.Now(),
+	})
+}
+
+func (s *service) Unsubscribe(ctx context.Context, connectionID, subscriptionID string) error {
+	return s.repo.remove(ctx, connectionID, subscriptionID)
+}
+
+// RemoveConnection removes all subscriptions of the connection
+func (s *service) RemoveConnection(ctx context.Context, connectionID string) error {
+	return s.repo.removeConnection(ctx, connectionID)
+}
+
+// Count returns the number of subscriptions of the connection
+func (s *service) Count(ctx context.Context, connectionID string) (int, error) {
+	return s.repo.count(ctx, connectionID)
+}
+
+// Matching returns the subscriptions with at least one filter matching the event.
+// Only the subscriptions sharing an index key with the event are checked.
+func (s *service) Matching(ctx context.Context, e events.Event) ([]Match, error) {
+	var matches []Match
+	err := s.repo.candidates(ctx, e.IndexKeys(), func(sub subscription) error {
+		if events.MatchesAny(sub.Filters, e) {
+			matches = append(matches, Match{ConnectionID: sub.ConnectionID, SubscriptionID: sub.SubscriptionID})
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// indexKeys returns the distinct index keys of the filters
+func indexKeys(filters []events.Filter) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, f := range filters {
+		for _, key := range f.IndexKeys() {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,210 @@
+package subscriptions
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+)
+
+func TestMatching(t *testing.T) {
+	svc := NewService(WithRepo(NewMemoryRepository()))
+	ctx := context.Background()
+	mustSubscribe(t, svc, "con1", "notes", events.Filter{Kinds: []int{1}})
+	mustSubscribe(t, svc, "con1", "profiles", events.Filter{Kinds: []int{0}})
+	mustSubscribe(t, svc, "con2", "mentions", events.Filter{Kinds: []int{0}}, events.Filter{Tags: map[string][]string{"p": {"pk"}}})
+	mustSubscribe(t, svc, "con3", "closed", events.Filter{})
+	if err := svc.Unsubscribe(ctx, "con3", "closed"); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+
+	cases := map[string]struct {
+		event events.Event
+		want  []Match
+	}{
+		"single match": {
+			event: events.Event{Kind: 1},
+			want:  []Match{{ConnectionID: "con1", SubscriptionID: "notes"}},
+		},
+		"one of the filters": {
+			event: events.Event{Kind: 7, Tags: [][]string{{"p", "pk"}}},
+			want:  []Match{{ConnectionID: "con2", SubscriptionID: "mentions"}},
+		},
+		"candidate that does not match": {
+			event: events.Event{Kind: 7, Tags: [][]string{{"p", "other"}, {"e", "pk"}}},
+		},
+		"several connections": {
+			event: events.Event{Kind: 0},
+			want:  []Match{{ConnectionID: "con1", SubscriptionID: "profiles"}, {ConnectionID: "con2", SubscriptionID: "mentions"}},
+		},
+		"no match": {
+			event: events.Event{Kind: 3},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := svc.Matching(ctx, testCase.event)
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			sortMatches(got)
+			verify.Values(t, "matches", got, testCase.want)
+		})
+	}
+}
+
+func TestMatchingLegacySubscriptions(t *testing.T) {
+	repo := NewMemoryRepository()
+	svc := NewService(WithRepo(repo))
+	ctx := context.Background()
+	legacy := subscription{ConnectionID: "con1", SubscriptionID: "legacy", Filters: []events.Filter{{Kinds: []int{1}}}}
+	if err := repo.put(ctx, legacy); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+
+	got, err := svc.Matching(ctx, events.Event{Kind: 1})
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "matches", got, []Match{{ConnectionID: "con1", SubscriptionID: "legacy"}})
+	got, err = svc.Matching(ctx, events.Event{Kind: 2})
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "no matches", len(got), 0)
+	verify.Values(t, "query", candidatesQuery([]string{"k:1"}), bson.M{"$or": bson.A{
+		bson.M{"index_keys": bson.M{"$in": []string{"k:1"}}},
+		bson.M{"index_keys": bson.M{"$exists": false}},
+	}})
+}
+
+func TestSubscribeReplaces(t *testing.T) {
+	repo := NewMemoryRepository().(*memoryRepository)
+	svc := NewService(WithRepo(repo))
+	mustSubscribe(t, svc, "con1", "sub", events.Filter{Kinds: []int{1}})
+	mustSubscribe(t, svc, "con1", "sub", events.Filter{Kinds: []int{0}})
+
+	got, err := svc.Matching(context.Background(), events.Event{Kind: 1})
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "matches", len(got), 0)
+	verify.Values(t, "subscriptions", len(repo.subs), 1)
+	verify.Values(t, "index", len(repo.index), 1)
+	count, err := svc.Count(context.Background(), "con1")
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "count", count, 1)
+}
+
+// BenchmarkMatching compares finding the matching subscriptions through the
+// index keys with checking every subscription
+func BenchmarkMatching(b *testing.B) {
+	for _, count := range []int{10_000, 100_000} {
+		indexed := NewMemoryRepository()
+		scanned := &scanRepository{}
+		random := rand.New(rand.NewSource(1))
+		for i := 0; i < count; i++ {
+			sub := randomSubscription(random, i)
+			_ = indexed.put(context.Background(), sub)
+			_ = scanned.put(context.Background(), sub)
+		}
+		e := events.Event{ID: "id", PubKey: randomPubKey(random), Kind: 1, Tags: [][]string{{"p", randomPubKey(random)}}}
+		for name, repo := range map[string]Repository{"indexed": indexed, "scanned": scanned} {
+			svc := NewService(WithRepo(repo))
+			b.Run(fmt.Sprintf("%s %d", name, count), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := svc.Matching(context.Background(), e); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+/// Helper Functions ///
+
+func mustSubscribe(t *testing.T, svc Service, connectionID, subscriptionID string, filters ...events.Filter) {
+	if err := svc.Subscribe(context.Background(), connectionID, subscriptionID, filters); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+}
+
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].ConnectionID+matches[i].SubscriptionID < matches[j].ConnectionID+matches[j].SubscriptionID
+	})
+}
+
+// randomSubscription returns a subscription with a mix of filters like a relay
+// sees: mostly follows of authors, some mentions, and a few broad filters
+func randomSubscription(random *rand.Rand, i int) subscription {
+	var filter events.Filter
+	switch n := random.Intn(100); {
+	case n < 60:
+		for j := 0; j < 1+random.Intn(20); j++ {
+			filter.Authors = append(filter.Authors, randomPubKey(random))
+		}
+		filter.Kinds = []int{1, 6, 7}
+	case n < 85:
+		filter.Tags = map[string][]string{"p": {randomPubKey(random)}}
+	case n < 99:
+		filter.Kinds = []int{random.Intn(40000)}
+	default:
+		since := int64(1700000000)
+		filter.Since = &since
+	}
+	filters := []events.Filter{filter}
+	return subscription{
+		ConnectionID:   fmt.Sprintf("con%d", i),
+		SubscriptionID: "sub",
+		Filters:        filters,
+		IndexKeys:      indexKeys(filters),
+	}
+}
+
+// randomPubKey returns one of 10000 pubkeys
+func randomPubKey(random *rand.Rand) string {
+	return fmt.Sprintf("pk%d", random.Intn(10_000))
+}
+
+/// Helper Types ///
+
+// scanRepository returns all subscriptions as candidates
+type scanRepository struct {
+	subs []subscription
+}
+
+func (r *scanRepository) put(_ context.Context, sub subscription) error {
+	r.subs = append(r.subs, sub)
+	return nil
+}
+
+func (r *scanRepository) remove(context.Context, string, string) error {
+	return nil
+}
+
+func (r *scanRepository) removeConnection(context.Context, string) error {
+	return nil
+}
+
+func (r *scanRepository) count(context.Context, string) (int, error) {
+	return len(r.subs), nil
+}
+
+func (r *scanRepository) candidates(_ context.Context, _ []string, fn func(subscription) error) error {
+	for _, sub := range r.subs {
+		if err := fn(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+// The routes of the admin HTTP API
+const (
+	routeListConnections  = "GET /admin/connections"
+	routeGetConnection    = "GET /admin/connections/{id}"
+	routeDeleteConnection = "DELETE /admin/connections/{id}"
+)
+
+// errInvalidQuery is returned for query parameters that can not be parsed
+var errInvalidQuery = errkind.New(errkind.Validation, "invalid query")
+
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret      string       `env:"DB_SECRET,required"`
+	LogLevel      logrus.Level `env:"LOG_LEVEL" default:"info"`
+	WSAPIEndpoint string       `env:"WS_API_ENDPOINT,required"`
+}
+
+type handler struct {
+	responder   apigateway.ProxyResponder
+	connections connections.Service
+}
+
+// listResponse is a page of connections, next is the page_token for the next page
+type listResponse struct {
+	Connections []connections.Connection `json:"connections"`
+	Next        string                   `json:"next,omitempty"`
+}
+
+func mustNewHandler() *handler {
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	subs := subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db)))
+	return &handler{
+		connections: connections.NewService(
+			connections.WithRepo(connections.NewRepository(db)),
+			connections.WithDisconnector(apigateway.MustNewConnectionPoster(cfg.WSAPIEndpoint)),
+			connections.WithOnRemove(subs.RemoveConnection),
+		),
+	}
+}
+
+func (h *handler) handleRequest(ctx context.Context, request awsevents.APIGatewayV2HTTPRequest) (apigateway.Response, error) {
+	switch request.RouteKey {
+	case routeListConnections:
+		return h.list(ctx, request.QueryStringParameters, request.Headers), nil
+	case routeGetConnection:
+		return h.get(ctx, request.PathParameters["id"]), nil
+	case routeDeleteConnection:
+		return h.disconnect(ctx, request.PathParameters["id"]), nil
+	}
+	return h.responder.WithStatus(http.StatusNotFound), nil
+}
+
+// list returns the connections matching the source_ip, pubkey, stage, user_agent and idle_since parameters,
+// compressed as the headers of the request accept
+func (h *handler) list(ctx context.Context, params, headers map[string]string) apigateway.Response {
+	q, err := query(params)
+	if err != nil {
+		return h.responder.WithError(err)
+	}
+	page, err := h.connections.List(ctx, q)
+	if err != nil {
+		return h.responder.WithError(fmt.Errorf("listing connections: %w", err))
+	}
+	return h.responder.WithStatus(http.StatusOK).WithJSONBody(listResponse{
+		Connections: page.Items,
+		Next:        page.Next,
+	}).WithCompression(headers)
+}
+
+func (h *handler) get(ctx context.Context, id string) apigateway.Response {
+	con, err := h.connections.Get(ctx, id)
+	if err != nil {
+		return h.responder.WithError(fmt.Errorf("getting connection %s: %w", id, err))
+	}
+	return h.responder.WithStatus(http.StatusOK).WithJSONBody(con)
+}
+
+// disconnect forcibly closes the connection, and removes it with its subscriptions
+func (h *handler) disconnect(ctx context.Context, id string) apigateway.Response {
+	log.Printf("disconnecting %s", id)
+	if err := h.connections.Disconnect(ctx, id); err != nil {
+		return h.responder.WithError(fmt.Errorf("disconnecting %s: %w", id, err))
+	}
+	return h.responder.WithStatus(http.StatusNoContent)
+}
+
+func query(params map[string]string) (connections.Query, error) {
+	q := connections.Query{
+		SourceIP:  params["source_ip"],
+		Pubkey:    params["pubkey"],
+		Stage:     params["stage"],
+		UserAgent: params["user_agent"],
+		Token:     params["page_token"],
+	}
+	if limit, found := params["limit"]; found {
+		var err error
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, fmt.Errorf("%w: limit %q is not a number", errInvalidQuery, limit)
+		}
+	}
+	if idleSince, found := params["idle_since"]; found {
+		var err error
+		if q.IdleSince, err = time.Parse(time.RFC3339, idleSince); err != nil {
+			return q, fmt.Errorf("%w: idle_since %q is not an RFC 3339 time", errInvalidQuery, idleSince)
+		}
+	}
+	return q, nil
+}
+
+func main() {
+	h := mustNewHandler()
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
+}
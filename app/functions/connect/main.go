@@ -2,46 +2,63 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/sirupsen/logrus"
 	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/relay"
 	"github.com/superkruger/nostr_app_data/app/utils/env"
 	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
 
 	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
 )
 
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret string       `env:"DB_SECRET,required"`
+	LogLevel logrus.Level `env:"LOG_LEVEL" default:"info"`
+}
+
 type handler struct {
 	responder apigateway.ProxyResponder
 	service   connections.Service
-	shutdown  func()
+	relay     relay.Service
 }
 
 func mustNewHandler() *handler {
-	db, closeDb := skmongo.MustFromSecretWithClose(env.MustGetString("DB_SECRET"))
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
 	return &handler{
 		service: connections.NewService(connections.WithRepo(connections.NewRepository(db))),
-		shutdown: func() {
-			closeDb()
-		},
+		relay:   relay.NewService(relay.WithRepo(relay.NewRepository(db))),
 	}
 }
 
 func (h *handler) handleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
 	log.Printf("got request %+v", request)
 	log.Printf("connecting: %s", request.RequestContext.ConnectionID)
-	if err := h.service.AddConnection(ctx, request.RequestContext.ConnectionID, time.Now()); err != nil {
-		log.Printf("error adding connection: %v", err)
-		return h.responder.WithStatus(http.StatusInternalServerError), nil
+	blocked, err := h.relay.IsBlockedIP(ctx, request.RequestContext.Identity.SourceIP)
+	if err != nil {
+		return h.responder.WithError(fmt.Errorf("checking blocked ips: %w", err)), nil
+	}
+	if blocked {
+		log.Printf("refusing connection from blocked ip %s", request.RequestContext.Identity.SourceIP)
+		return h.responder.WithStatus(http.StatusForbidden), nil
+	}
+	if err := h.service.AddConnection(ctx, connections.FromRequest(request, time.Now())); err != nil {
+		return h.responder.WithError(fmt.Errorf("adding connection: %w", err)), nil
 	}
 	return h.responder.WithStatus(http.StatusOK), nil
 }
 
 func main() {
 	h := mustNewHandler()
-	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(h.shutdown))
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
 }
@@ -2,30 +2,74 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/aws/aws-lambda-go/events"
+	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
 	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
 )
 
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret string       `env:"DB_SECRET,required"`
+	LogLevel logrus.Level `env:"LOG_LEVEL" default:"info"`
+}
+
 type handler struct {
-	responder apigateway.ProxyResponder
+	connections   connections.Service
+	responder     apigateway.ProxyResponder
+	subscriptions subscriptions.Service
 }
 
 func mustNewHandler() *handler {
-	return &handler{}
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	return &handler{
+		connections:   connections.NewService(connections.WithRepo(connections.NewRepository(db))),
+		subscriptions: subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db))),
+	}
 }
 
-func (h *handler) handleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
-	log.Printf("default route for %s", request.RequestContext.ConnectionID)
-	log.Printf("got request: %+v", request.Body)
+func (h *handler) handleRequest(ctx context.Context, request awsevents.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
+	connectionID := request.RequestContext.ConnectionID
+	log.Printf("default route for %s", connectionID)
+	activity := connections.Activity{At: time.Now(), BytesIn: len(request.Body)}
+	defer func() {
+		if err := h.connections.Record(ctx, connectionID, activity); err != nil {
+			log.Printf("error recording activity: %v", err)
+		}
+	}()
+	msg, err := events.ParseClientMessage([]byte(request.Body))
+	if err != nil {
+		log.Printf("got invalid request: %+v", request.Body)
+		return h.responder.WithStatus(http.StatusOK), nil
+	}
+	if msg.Type == events.MessageClose {
+		if err := h.subscriptions.Unsubscribe(ctx, connectionID, msg.SubscriptionID); err != nil {
+			return h.responder.WithError(fmt.Errorf("removing subscription %s: %w", msg.SubscriptionID, err)), nil
+		}
+		if count, err := h.subscriptions.Count(ctx, connectionID); err != nil {
+			log.Printf("error counting subscriptions: %v", err)
+		} else {
+			activity.Subscriptions = &count
+		}
+	}
 	return h.responder.WithStatus(http.StatusOK), nil
 }
 
 func main() {
 	h := mustNewHandler()
-	lambda.Start(h.handleRequest)
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
 }
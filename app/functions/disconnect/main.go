@@ -2,29 +2,57 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
 	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
 )
 
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret string       `env:"DB_SECRET,required"`
+	LogLevel logrus.Level `env:"LOG_LEVEL" default:"info"`
+}
+
 type handler struct {
-	responder apigateway.ProxyResponder
+	responder     apigateway.ProxyResponder
+	connections   connections.Service
+	subscriptions subscriptions.Service
 }
 
 func mustNewHandler() *handler {
-	return &handler{}
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	return &handler{
+		connections:   connections.NewService(connections.WithRepo(connections.NewRepository(db))),
+		subscriptions: subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db))),
+	}
 }
 
 func (h *handler) handleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
-	log.Printf("disconnecting: %s", request.RequestContext.ConnectionID)
+	connectionID := request.RequestContext.ConnectionID
+	log.Printf("disconnecting: %s", connectionID)
+	if err := h.subscriptions.RemoveConnection(ctx, connectionID); err != nil {
+		return h.responder.WithError(fmt.Errorf("removing subscriptions of %s: %w", connectionID, err)), nil
+	}
+	if err := h.connections.RemoveConnection(ctx, connectionID); err != nil {
+		return h.responder.WithError(fmt.Errorf("removing connection %s: %w", connectionID, err)), nil
+	}
 	return h.responder.WithStatus(http.StatusOK), nil
 }
 
 func main() {
 	h := mustNewHandler()
-	lambda.Start(h.handleRequest)
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
 }
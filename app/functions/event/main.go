@@ -2,48 +2,137 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"time"
 
-	"github.com/aws/aws-lambda-go/events"
+	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
-	"github.com/aws/jsii-runtime-go"
 
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/domain/fanout"
+	"github.com/superkruger/nostr_app_data/app/domain/relay"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
 	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/queue"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
 )
 
+const (
+	// fanoutSync broadcasts accepted events from this function
+	fanoutSync = "sync"
+	// fanoutStream leaves broadcasting stored events to the broadcaster worker
+	// following the events collection, see cmd/broadcaster
+	fanoutStream = "stream"
+	// fanoutQueue puts accepted events on the QUEUE_URL queue, for the fan-out function
+	fanoutQueue = "queue"
+)
+
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret      string       `env:"DB_SECRET,required"`
+	LogLevel      logrus.Level `env:"LOG_LEVEL" default:"info"`
+	WSAPIEndpoint string       `env:"WS_API_ENDPOINT,required"`
+	FanoutMode    string       `env:"FANOUT_MODE" default:"sync"`
+	// QueueURL is required in the queue fanout mode
+	QueueURL string `env:"QUEUE_URL"`
+	// AllowlistPubkeys and AllowlistKinds only accept the pubkeys and kinds allowed through the management API
+	AllowlistPubkeys bool `env:"ALLOWLIST_PUBKEYS"`
+	AllowlistKinds   bool `env:"ALLOWLIST_KINDS"`
+}
+
 type handler struct {
-	responder           apigateway.ProxyResponder
-	managementApiClient *apigatewaymanagementapi.Client
+	connections connections.Service
+	responder   apigateway.ProxyResponder
+	events      events.Service
+	fanout      fanout.Service
+	poster      apigateway.Sender
+	fanoutMode  string
 }
 
 func mustNewHandler() *handler {
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	poster := apigateway.MustNewConnectionPoster(cfg.WSAPIEndpoint)
+	subs := subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db)))
+	policy := relay.NewService(
+		relay.WithRepo(relay.NewRepository(db)),
+		relay.WithAllowlist(relay.Allowlist{Pubkeys: cfg.AllowlistPubkeys, Kinds: cfg.AllowlistKinds}),
+	)
+	var q queue.Queue
+	if cfg.FanoutMode == fanoutQueue {
+		if cfg.QueueURL == "" {
+			panic(fmt.Errorf("missing env variable: QUEUE_URL"))
+		}
+		q = queue.NewSQSQueue(cfg.QueueURL)
+	}
 	return &handler{
-		managementApiClient: apigatewaymanagementapi.New(apigatewaymanagementapi.Options{
-			BaseEndpoint: jsii.String(os.Getenv("WS_API_ENDPOINT")),
-			Region:       os.Getenv("AWS_REGION"),
-		}),
+		connections: connections.NewService(connections.WithRepo(connections.NewRepository(db))),
+		events:      events.NewService(events.WithRepo(events.NewRepository(db)), events.WithPolicy(policy)),
+		fanout:      fanout.NewService(fanout.WithSubscriptions(subs), fanout.WithPoster(poster), fanout.WithQueue(q)),
+		poster:      poster,
+		fanoutMode:  cfg.FanoutMode,
 	}
 }
 
-func (h *handler) handleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
-	log.Printf("got event %+v", request)
-	log.Printf("sending events to %s", *h.managementApiClient.Options().BaseEndpoint)
-	// for each connection with a request matching the event
-	//_, err := h.managementApiClient.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
-	//	ConnectionId: nil, //
-	//	Data:         nil,
-	//})
-	//if err != nil {
-	//	log.Println(err)
-	//	return h.responder.WithStatus(http.StatusInternalServerError), err
-	//}
+func (h *handler) handleRequest(ctx context.Context, request awsevents.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
+	connectionID := request.RequestContext.ConnectionID
+	activity := connections.Activity{At: time.Now(), BytesIn: len(request.Body)}
+	respond := apigateway.NewNostrResponder(ctx, h.poster, connectionID)
+	defer h.connections.RecordReplies(ctx, connectionID, &activity, respond)
+	msg, err := events.ParseClientMessage([]byte(request.Body))
+	if err != nil || msg.Type != events.MessageEvent {
+		log.Printf("invalid message from %s: %v", connectionID, err)
+		_ = respond.Notice(events.ErrInvalidMessage.Error())
+		return h.responder.WithStatus(http.StatusOK), nil
+	}
+
+	err = h.events.Accept(ctx, msg.Event)
+	accepted, message := okResult(err)
+	if !accepted {
+		log.Printf("event %s not accepted: %v", msg.Event.ID, err)
+	}
+	_ = respond.OK(msg.Event.ID, accepted, message)
+	if err != nil {
+		return h.responder.WithStatus(http.StatusOK), nil
+	}
+
+	if h.fanoutMode == fanoutQueue {
+		err := h.fanout.Enqueue(ctx, msg.Event)
+		if err == nil {
+			return h.responder.WithStatus(http.StatusOK), nil
+		}
+		// the client was told the event was accepted, so it is broadcast from here rather than lost
+		log.Printf("error queueing event %s, broadcasting it instead: %v", msg.Event.ID, err)
+	}
+	// ephemeral events are not stored, so the broadcaster worker never sees them
+	if h.fanoutMode != fanoutStream || msg.Event.IsEphemeral() {
+		delivered, err := h.fanout.Broadcast(ctx, msg.Event)
+		if err != nil {
+			log.Printf("error broadcasting event %s: %v", msg.Event.ID, err)
+		}
+		log.Printf("delivered event %s to %d subscriptions", msg.Event.ID, delivered)
+	}
 	return h.responder.WithStatus(http.StatusOK), nil
 }
 
+// okResult returns the fields of the OK message for the result of accepting an event
+func okResult(err error) (bool, string) {
+	if err == nil {
+		return true, ""
+	}
+	// a duplicate was accepted before, see NIP-01
+	return errkind.Of(err) == errkind.Conflict, apigateway.NostrReason(err, "could not store event")
+}
+
 func main() {
 	h := mustNewHandler()
-	lambda.Start(h.handleRequest)
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
 }
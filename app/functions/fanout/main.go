@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/fanout"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret      string       `env:"DB_SECRET,required"`
+	LogLevel      logrus.Level `env:"LOG_LEVEL" default:"info"`
+	WSAPIEndpoint string       `env:"WS_API_ENDPOINT,required"`
+	Concurrency   int          `env:"FANOUT_CONCURRENCY" default:"16"`
+}
+
+type handler struct {
+	fanout fanout.Service
+}
+
+func mustNewHandler() *handler {
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	subs := subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db)))
+	return &handler{
+		fanout: fanout.NewService(
+			fanout.WithSubscriptions(subs),
+			fanout.WithPoster(apigateway.MustNewConnectionPoster(cfg.WSAPIEndpoint)),
+			fanout.WithConcurrency(cfg.Concurrency),
+		),
+	}
+}
+
+func (h *handler) handleRequest(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	response, err := h.fanout.HandleQueued(ctx, event)
+	if len(response.BatchItemFailures) > 0 {
+		log.Printf("failed to deliver %d of %d queued events", len(response.BatchItemFailures), len(event.Records))
+	}
+	return response, err
+}
+
+func main() {
+	h := mustNewHandler()
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
+}
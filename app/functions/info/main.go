@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/relay"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+// maxMessageLength is the frame size limit of API Gateway websockets
+const maxMessageLength = 128 * 1024
+
+// defaultCORS lets browser based clients on any origin read the document, see NIP-11
+var defaultCORS = apigateway.CORS{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{http.MethodGet, http.MethodOptions},
+	AllowedHeaders: []string{"Accept"},
+	MaxAge:         24 * time.Hour,
+}
+
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret    string       `env:"DB_SECRET,required"`
+	LogLevel    logrus.Level `env:"LOG_LEVEL" default:"info"`
+	Name        string       `env:"RELAY_NAME" default:"nostr_app_data"`
+	Description string       `env:"RELAY_DESCRIPTION"`
+	Icon        string       `env:"RELAY_ICON"`
+	Pubkey      string       `env:"RELAY_PUBKEY"`
+	Contact     string       `env:"RELAY_CONTACT"`
+	Version     string       `env:"RELAY_VERSION"`
+	// MaxLimit is the most stored events the REQ function sends per filter
+	MaxLimit int `env:"MAX_LIMIT" default:"500"`
+	// AllowlistPubkeys and AllowlistKinds are those of the EVENT function, for the restricted_writes limitation
+	AllowlistPubkeys bool `env:"ALLOWLIST_PUBKEYS"`
+	AllowlistKinds   bool `env:"ALLOWLIST_KINDS"`
+	CORS             apigateway.CORS
+}
+
+type handler struct {
+	responder apigateway.ProxyResponder
+	relay     relay.Service
+}
+
+func mustNewHandler() *handler {
+	cfg := config{CORS: defaultCORS}
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	return &handler{
+		responder: apigateway.NewCORSResponder(cfg.CORS),
+		relay: relay.NewService(
+			relay.WithRepo(relay.NewRepository(db)),
+			relay.WithDocument(relay.Document{
+				Name:          cfg.Name,
+				Description:   cfg.Description,
+				Icon:          cfg.Icon,
+				Pubkey:        cfg.Pubkey,
+				Contact:       cfg.Contact,
+				SupportedNIPs: []int{1, 11, 86, 98},
+				Software:      "https://github.com/superkruger/nostr_app_data",
+				Version:       cfg.Version,
+				Limitation: relay.Limitation{
+					MaxMessageLength: maxMessageLength,
+					MaxLimit:         cfg.MaxLimit,
+				},
+			}),
+			relay.WithAllowlist(relay.Allowlist{Pubkeys: cfg.AllowlistPubkeys, Kinds: cfg.AllowlistKinds}),
+		),
+	}
+}
+
+func (h *handler) handleRequest(ctx context.Context, _ awsevents.APIGatewayV2HTTPRequest) (apigateway.Response, error) {
+	document, err := h.relay.Document(ctx)
+	if err != nil {
+		return h.responder.WithError(fmt.Errorf("building relay information document: %w", err)), nil
+	}
+	response := h.responder.WithStatus(http.StatusOK).WithJSONBody(document)
+	response.Headers["Content-Type"] = relay.ContentTypeInfo
+	return response, nil
+}
+
+func main() {
+	h := mustNewHandler()
+	lambda.StartWithOptions(h.responder.Wrap(h.handleRequest), lambda.WithEnableSIGTERM(skmongo.CloseAll))
+}
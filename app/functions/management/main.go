@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/relay"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+// defaultCORS lets browser based clients send management requests, and read why they are unauthorized.
+// This function answers the preflight requests of the relay API, so it allows the GET of the information document.
+var defaultCORS = apigateway.CORS{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodOptions},
+	AllowedHeaders: []string{"Authorization", "Content-Type"},
+	ExposedHeaders: []string{"WWW-Authenticate"},
+	MaxAge:         24 * time.Hour,
+}
+
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret     string       `env:"DB_SECRET,required"`
+	LogLevel     logrus.Level `env:"LOG_LEVEL" default:"info"`
+	AdminPubkeys []string     `env:"ADMIN_PUBKEYS"`
+	// ManagementURL is the url of the authorization events, the url of the request when it is not set
+	ManagementURL string `env:"MANAGEMENT_URL"`
+	CORS          apigateway.CORS
+}
+
+type handler struct {
+	responder apigateway.ProxyResponder
+	auth      apigateway.NostrAuth
+	relay     relay.Service
+}
+
+func mustNewHandler() *handler {
+	cfg := config{CORS: defaultCORS}
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	responder := apigateway.NewCORSResponder(cfg.CORS)
+	return &handler{
+		responder: responder,
+		auth:      apigateway.NewNostrAuth(responder, cfg.ManagementURL).WithPubkeys(cfg.AdminPubkeys),
+		relay:     relay.NewService(relay.WithRepo(relay.NewRepository(db))),
+	}
+}
+
+// handleRequest executes a NIP-86 management request of one of the admin pubkeys
+func (h *handler) handleRequest(ctx context.Context, request awsevents.APIGatewayV2HTTPRequest) (apigateway.Response, error) {
+	// the auth middleware only lets the admin pubkeys through
+	pubkey, _ := apigateway.PubkeyFromContext(ctx)
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		// the body was decoded before to check the payload of the authorization
+		body, _ = base64.StdEncoding.DecodeString(request.Body)
+	}
+	var rpcRequest relay.Request
+	if err := json.Unmarshal(body, &rpcRequest); err != nil {
+		return h.rpcResponse(http.StatusBadRequest, relay.Response{Error: "request could not be parsed"}), nil
+	}
+	log.Printf("management request %s by %s", rpcRequest.Method, pubkey)
+	return h.rpcResponse(http.StatusOK, h.relay.Manage(ctx, rpcRequest)), nil
+}
+
+func (h *handler) rpcResponse(status int, response relay.Response) apigateway.Response {
+	r := h.responder.WithStatus(status).WithJSONBody(response)
+	r.Headers["Content-Type"] = relay.ContentTypeRPC
+	return r
+}
+
+func main() {
+	h := mustNewHandler()
+	// preflight requests carry no authorization, so they are answered before authenticating
+	lambda.StartWithOptions(h.responder.Wrap(h.auth.Wrap(h.handleRequest)), lambda.WithEnableSIGTERM(skmongo.CloseAll))
+}
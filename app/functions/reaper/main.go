@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/aws/metrics"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
+)
+
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret         string       `env:"DB_SECRET,required"`
+	LogLevel         logrus.Level `env:"LOG_LEVEL" default:"info"`
+	WSAPIEndpoint    string       `env:"WS_API_ENDPOINT,required"`
+	MetricsNamespace string       `env:"METRICS_NAMESPACE" default:"NostrAppData"`
+}
+
+type handler struct {
+	connections connections.Service
+	metrics     metrics.Logger
+}
+
+func mustNewHandler() *handler {
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	subs := subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db)))
+	return &handler{
+		connections: connections.NewService(
+			connections.WithRepo(connections.NewRepository(db)),
+			connections.WithProber(apigateway.MustNewConnectionPoster(cfg.WSAPIEndpoint)),
+			connections.WithOnRemove(subs.RemoveConnection),
+		),
+		metrics: metrics.NewLogger(cfg.MetricsNamespace, map[string]string{"Function": "Reaper"}),
+	}
+}
+
+func (h *handler) handleRequest(ctx context.Context, _ events.CloudWatchEvent) error {
+	report, err := h.connections.Reap(ctx, time.Now())
+	log.Printf("checked %d stale connections, removed %d", report.Checked, report.Removed)
+	if metricsErr := h.metrics.Put(
+		metrics.Metric{Name: "StaleConnections", Value: float64(report.Checked), Unit: metrics.UnitCount},
+		metrics.Metric{Name: "ReapedConnections", Value: float64(report.Removed), Unit: metrics.UnitCount},
+	); metricsErr != nil {
+		log.Printf("error writing metrics: %v", metricsErr)
+	}
+	return err
+}
+
+func main() {
+	h := mustNewHandler()
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
+}
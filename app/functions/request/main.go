@@ -2,29 +2,111 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/aws/aws-lambda-go/events"
+	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/sirupsen/logrus"
+	"github.com/superkruger/nostr_app_data/app/domain/connections"
+	"github.com/superkruger/nostr_app_data/app/domain/events"
+	"github.com/superkruger/nostr_app_data/app/domain/subscriptions"
 	"github.com/superkruger/nostr_app_data/app/utils/aws/apigateway"
+	"github.com/superkruger/nostr_app_data/app/utils/env"
+	"github.com/superkruger/nostr_app_data/app/utils/skmongo"
 )
 
+// config is read from the environment, see functions/ENVIRONMENT.md
+type config struct {
+	DBSecret      string       `env:"DB_SECRET,required"`
+	LogLevel      logrus.Level `env:"LOG_LEVEL" default:"info"`
+	WSAPIEndpoint string       `env:"WS_API_ENDPOINT,required"`
+	// MaxLimit is the most stored events sent per filter, also when the filter has no or a higher limit
+	MaxLimit int `env:"MAX_LIMIT" default:"500"`
+}
+
 type handler struct {
-	responder apigateway.ProxyResponder
+	connections   connections.Service
+	responder     apigateway.ProxyResponder
+	events        events.Service
+	subscriptions subscriptions.Service
+	poster        apigateway.Sender
+	maxLimit      int
 }
 
 func mustNewHandler() *handler {
-	return &handler{}
+	var cfg config
+	env.MustLoad(&cfg, env.WithLocalDotenv())
+	logrus.SetLevel(cfg.LogLevel)
+	db := skmongo.Shared(cfg.DBSecret)
+	return &handler{
+		connections:   connections.NewService(connections.WithRepo(connections.NewRepository(db))),
+		events:        events.NewService(events.WithRepo(events.NewRepository(db))),
+		subscriptions: subscriptions.NewService(subscriptions.WithRepo(subscriptions.NewRepository(db))),
+		poster:        apigateway.MustNewConnectionPoster(cfg.WSAPIEndpoint),
+		maxLimit:      cfg.MaxLimit,
+	}
 }
 
-func (h *handler) handleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
-	log.Printf("got request %+v", request)
+func (h *handler) handleRequest(ctx context.Context, request awsevents.APIGatewayWebsocketProxyRequest) (apigateway.Response, error) {
+	connectionID := request.RequestContext.ConnectionID
+	activity := connections.Activity{At: time.Now(), BytesIn: len(request.Body)}
+	respond := apigateway.NewNostrResponder(ctx, h.poster, connectionID)
+	defer h.connections.RecordReplies(ctx, connectionID, &activity, respond)
+	msg, err := events.ParseClientMessage([]byte(request.Body))
+	if err != nil || msg.Type != events.MessageReq {
+		log.Printf("invalid message from %s: %v", connectionID, err)
+		_ = respond.Notice(events.ErrInvalidMessage.Error())
+		return h.responder.WithStatus(http.StatusOK), nil
+	}
+
+	if err := h.subscriptions.Subscribe(ctx, connectionID, msg.SubscriptionID, msg.Filters); err != nil {
+		log.Printf("error storing subscription %s of %s: %v", msg.SubscriptionID, connectionID, err)
+		_ = respond.Closed(msg.SubscriptionID, apigateway.NostrReason(err, "could not store subscription"))
+		return h.responder.WithStatus(http.StatusOK), nil
+	}
+	h.countSubscriptions(ctx, connectionID, &activity)
+
+	sent := map[string]bool{}
+	for _, filter := range msg.Filters {
+		if filter.Limit <= 0 || filter.Limit > h.maxLimit {
+			filter.Limit = h.maxLimit
+		}
+		err := h.events.Query(ctx, filter, func(e events.Event) error {
+			if sent[e.ID] {
+				return nil
+			}
+			sent[e.ID] = true
+			// an event too large for a frame is skipped, the others can still be sent
+			if err := respond.Event(msg.SubscriptionID, e); err != nil && !errors.Is(err, apigateway.ErrFrameTooLarge) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("error sending stored events for %s of %s: %v", msg.SubscriptionID, connectionID, err)
+			_ = respond.Closed(msg.SubscriptionID, apigateway.NostrReason(err, "could not query events"))
+			return h.responder.WithStatus(http.StatusOK), nil
+		}
+	}
+	_ = respond.EOSE(msg.SubscriptionID)
 	return h.responder.WithStatus(http.StatusOK), nil
 }
 
+// countSubscriptions sets the number of subscriptions of the connection in the activity
+func (h *handler) countSubscriptions(ctx context.Context, connectionID string, activity *connections.Activity) {
+	count, err := h.subscriptions.Count(ctx, connectionID)
+	if err != nil {
+		log.Printf("error counting subscriptions of %s: %v", connectionID, err)
+		return
+	}
+	activity.Subscriptions = &count
+}
+
 func main() {
 	h := mustNewHandler()
-	lambda.Start(h.handleRequest)
+	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
 }
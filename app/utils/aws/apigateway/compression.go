@@ -0,0 +1,139 @@
+package apigateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The content codings of the Accept-Encoding and Content-Encoding headers
+const (
+	encodingBrotli   = "br"
+	encodingZstd     = "zstd"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// encodingPreference is the order in which the encodings are chosen when the client accepts them equally
+var encodingPreference = []string{encodingBrotli, encodingZstd, encodingGzip}
+
+// zstdEncoder is shared, as EncodeAll can be used concurrently and an encoder is costly to create
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+var encoders = map[string]func(body []byte) ([]byte, error){
+	encodingBrotli: func(body []byte) ([]byte, error) {
+		var b bytes.Buffer
+		w := brotli.NewWriterLevel(&b, brotli.DefaultCompression)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	},
+	encodingZstd: func(body []byte) ([]byte, error) {
+		return zstdEncoder.EncodeAll(body, nil), nil
+	},
+	encodingGzip: func(body []byte) ([]byte, error) {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	},
+}
+
+// negotiateEncoding returns the supported encoding with the highest q-value in
+// the Accept-Encoding header, or an empty string when the body should not be encoded
+func negotiateEncoding(acceptEncoding string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = encodingGzip
+		}
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(param, "=")
+			if found && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		weights[name] = weight
+	}
+	best, bestWeight := "", 0.0
+	for _, encoding := range encodingPreference {
+		weight, found := weights[encoding]
+		if !found {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+	if identity, found := weights[encodingIdentity]; found && identity > bestWeight {
+		return ""
+	}
+	return best
+}
+
+// isCompressedContentType tells if bodies of the content type are compressed already
+func isCompressedContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "font/woff"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-brotli", "application/x-7z-compressed", "application/x-bzip2", "application/x-xz":
+		return true
+	}
+	return false
+}
+
+// headerValue returns the value of the header, ignoring the case of the name as
+// API Gateway passes headers with their original case to REST APIs, and in lowercase to HTTP APIs
+func headerValue(headers map[string]string, name string) string {
+	if value, found := headers[name]; found {
+		return value
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// addToList adds the value to the comma separated list, unless it is there already
+func addToList(list, value string) string {
+	if list == "" {
+		return value
+	}
+	for _, item := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return list
+		}
+	}
+	return list + ", " + value
+}
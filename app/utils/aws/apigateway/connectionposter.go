@@ -0,0 +1,94 @@
+package apigateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+var (
+	// ErrGone is returned when posting to a connection that is no longer connected
+	ErrGone = errkind.New(errkind.NotFound, "connection is gone")
+	// ErrThrottled is returned when posting is throttled, also after the retries of the SDK
+	ErrThrottled = errkind.New(errkind.Unavailable, "posting to connection is throttled")
+)
+
+// ConnectionPoster posts messages to websocket connections, probes and closes
+// them, through the API Gateway management API
+type ConnectionPoster struct {
+	client *apigatewaymanagementapi.Client
+}
+
+// NewConnectionPoster creates a poster for the websocket stage at endpoint, as in
+// https://{api-id}.execute-api.{region}.amazonaws.com/{stage}, using the default AWS credentials
+func NewConnectionPoster(ctx context.Context, endpoint string) (ConnectionPoster, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return ConnectionPoster{}, fmt.Errorf("failed to load aws config: %w", err)
+	}
+	return ConnectionPoster{
+		client: apigatewaymanagementapi.NewFromConfig(cfg, func(o *apigatewaymanagementapi.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
+		}),
+	}, nil
+}
+
+// MustNewConnectionPoster creates a poster for the websocket stage at endpoint, and panics when that fails
+func MustNewConnectionPoster(endpoint string) ConnectionPoster {
+	poster, err := NewConnectionPoster(context.Background(), endpoint)
+	if err != nil {
+		panic(err)
+	}
+	return poster
+}
+
+// Post sends the data to the connection. It returns ErrGone when the connection
+// was closed, and ErrThrottled when the API rate limit was exceeded.
+func (p ConnectionPoster) Post(ctx context.Context, connectionID string, data []byte) error {
+	_, err := p.client.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(connectionID),
+		Data:         data,
+	})
+	var gone *types.GoneException
+	if errors.As(err, &gone) {
+		return fmt.Errorf("%w: %s", ErrGone, connectionID)
+	}
+	var limitExceeded *types.LimitExceededException
+	var apiErr smithy.APIError
+	if errors.As(err, &limitExceeded) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "TooManyRequestsException") {
+		return fmt.Errorf("%w: %v", ErrThrottled, err)
+	}
+	return err
+}
+
+// Probe checks if the connection is still there, and returns ErrGone when it is not
+func (p ConnectionPoster) Probe(ctx context.Context, connectionID string) error {
+	_, err := p.client.GetConnection(ctx, &apigatewaymanagementapi.GetConnectionInput{
+		ConnectionId: aws.String(connectionID),
+	})
+	var gone *types.GoneException
+	if errors.As(err, &gone) {
+		return fmt.Errorf("%w: %s", ErrGone, connectionID)
+	}
+	return err
+}
+
+// Disconnect closes the connection, and returns ErrGone when it was already closed
+func (p ConnectionPoster) Disconnect(ctx context.Context, connectionID string) error {
+	_, err := p.client.DeleteConnection(ctx, &apigatewaymanagementapi.DeleteConnectionInput{
+		ConnectionId: aws.String(connectionID),
+	})
+	var gone *types.GoneException
+	if errors.As(err, &gone) {
+		return fmt.Errorf("%w: %s", ErrGone, connectionID)
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package apigateway
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// CORS is the cross-origin resource sharing policy of a ProxyResponder
+type CORS struct {
+	// AllowedOrigins are the origins that may make requests, "*" for any origin, or patterns like https://*.example.com
+	AllowedOrigins []string `env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods []string `env:"CORS_ALLOWED_METHODS"`
+	AllowedHeaders []string `env:"CORS_ALLOWED_HEADERS"`
+	// ExposedHeaders are the response headers that scripts may read
+	ExposedHeaders []string `env:"CORS_EXPOSED_HEADERS"`
+	// MaxAge is how long browsers may cache the result of a preflight request
+	MaxAge time.Duration `env:"CORS_MAX_AGE"`
+}
+
+// NewCORSResponder creates a proxy responder with the CORS policy. Responses
+// allow any origin when the policy does, otherwise Wrap echoes the origin of
+// the requests that the policy allows.
+func NewCORSResponder(cors CORS) ProxyResponder {
+	r := ProxyResponder{cors: &cors}
+	if cors.allowsAnyOrigin() {
+		r.originAllowed = "*"
+	}
+	return r
+}
+
+// Wrap returns a handler that answers OPTIONS preflight requests, and that adds
+// the CORS headers for the origin of the request to the responses of next
+func (r ProxyResponder) Wrap(next HTTPHandler) HTTPHandler {
+	return func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (Response, error) {
+		if request.RequestContext.HTTP.Method == http.MethodOptions {
+			return r.Preflight(request.Headers), nil
+		}
+		response, err := next(ctx, request)
+		if err != nil {
+			return response, err
+		}
+		return r.withOrigin(response, headerValue(request.Headers, "Origin")), nil
+	}
+}
+
+// Preflight responds to an OPTIONS preflight request with the allowed methods and
+// headers. Requests from origins that are not allowed get no CORS headers, so
+// browsers refuse the actual request.
+func (r ProxyResponder) Preflight(requestHeaders map[string]string) Response {
+	response := r.withOrigin(r.WithStatus(http.StatusNoContent), headerValue(requestHeaders, "Origin"))
+	// exposed headers only apply to actual requests
+	delete(response.Headers, "Access-Control-Expose-Headers")
+	if r.cors == nil || response.Headers["Access-Control-Allow-Origin"] == "" {
+		return response
+	}
+	if len(r.cors.AllowedMethods) > 0 {
+		response.Headers["Access-Control-Allow-Methods"] = strings.Join(r.cors.AllowedMethods, ", ")
+	}
+	if len(r.cors.AllowedHeaders) > 0 {
+		response.Headers["Access-Control-Allow-Headers"] = strings.Join(r.cors.AllowedHeaders, ", ")
+	}
+	if r.cors.MaxAge > 0 {
+		response.Headers["Access-Control-Max-Age"] = strconv.Itoa(int(r.cors.MaxAge.Seconds()))
+	}
+	return response
+}
+
+// withOrigin echoes the origin in the response when the policy allows it
+func (r ProxyResponder) withOrigin(response Response, origin string) Response {
+	if r.cors == nil || r.originAllowed == "*" {
+		return response
+	}
+	// the response depends on the origin, also when it is not allowed
+	response.Headers["Vary"] = addToList(response.Headers["Vary"], "Origin")
+	if origin != "" && r.cors.allowsOrigin(origin) {
+		response.Headers["Access-Control-Allow-Origin"] = origin
+	}
+	return response
+}
+
+func (c CORS) allowsAnyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if strings.TrimSpace(allowed) == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrigin tells if the origin is one of the allowed origins, or matches one
+// of the patterns, in which * stands for one or more subdomains
+func (c CORS) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		prefix, suffix, isPattern := strings.Cut(allowed, "*")
+		if allowed == origin || isPattern && len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+			return true
+		}
+	}
+	return false
+}
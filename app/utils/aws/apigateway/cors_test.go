@@ -0,0 +1,119 @@
+package apigateway
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestCORS(t *testing.T) {
+	policy := CORS{
+		AllowedOrigins: []string{"https://client.example", "https://*.nostr.example"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		ExposedHeaders: []string{"WWW-Authenticate"},
+		MaxAge:         time.Hour,
+	}
+	cases := map[string]struct {
+		cors        CORS
+		method      string
+		origin      string
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		"allowed origin": {
+			cors:       policy,
+			method:     http.MethodGet,
+			origin:     "https://client.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://client.example",
+				"Access-Control-Expose-Headers": "WWW-Authenticate",
+				"Vary":                          "Origin",
+			},
+		},
+		"origin matching a pattern": {
+			cors:       policy,
+			method:     http.MethodGet,
+			origin:     "https://app.nostr.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.nostr.example",
+				"Access-Control-Expose-Headers": "WWW-Authenticate",
+				"Vary":                          "Origin",
+			},
+		},
+		"origin that only ends like a pattern": {
+			cors:       policy,
+			method:     http.MethodGet,
+			origin:     "https://evil.example/.nostr.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Expose-Headers": "WWW-Authenticate",
+				"Vary":                          "Origin",
+			},
+		},
+		"origin that is not allowed": {
+			cors:       policy,
+			method:     http.MethodGet,
+			origin:     "https://other.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Expose-Headers": "WWW-Authenticate",
+				"Vary":                          "Origin",
+			},
+		},
+		"preflight": {
+			cors:       policy,
+			method:     http.MethodOptions,
+			origin:     "https://client.example",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://client.example",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type",
+				"Access-Control-Max-Age":       "3600",
+				"Vary":                         "Origin",
+			},
+		},
+		"preflight of an origin that is not allowed": {
+			cors:       policy,
+			method:     http.MethodOptions,
+			origin:     "https://other.example",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Vary": "Origin",
+			},
+		},
+		"any origin": {
+			cors:       CORS{AllowedOrigins: []string{"*"}},
+			method:     http.MethodGet,
+			origin:     "https://other.example",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin": "*",
+			},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			responder := NewCORSResponder(testCase.cors)
+			handler := responder.Wrap(func(context.Context, events.APIGatewayV2HTTPRequest) (Response, error) {
+				return responder.WithStatus(http.StatusOK), nil
+			})
+			request := events.APIGatewayV2HTTPRequest{Headers: map[string]string{"origin": testCase.origin}}
+			request.RequestContext.HTTP.Method = testCase.method
+			response, err := handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			delete(response.Headers, "Cache-Control")
+			verify.Values(t, "status", response.StatusCode, testCase.wantStatus)
+			verify.Values(t, "headers", response.Headers, testCase.wantHeaders)
+		})
+	}
+}
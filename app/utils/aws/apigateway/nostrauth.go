@@ -0,0 +1,160 @@
+package apigateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+	"github.com/superkruger/nostr_app_data/app/utils/nostr"
+)
+
+const (
+	// KindHTTPAuth is the kind of the events authorizing HTTP requests, see NIP-98
+	KindHTTPAuth = 27235
+	// nostrAuthScheme is the scheme of the Authorization header carrying the event
+	nostrAuthScheme = "Nostr "
+	// nostrAuthWindow is how far the created_at of the event may be from now
+	nostrAuthWindow = 60 * time.Second
+)
+
+var (
+	// ErrUnauthorized is returned when a request does not carry a valid NIP-98 authorization
+	ErrUnauthorized = errkind.New(errkind.Unauthorized, "unauthorized")
+	// ErrForbidden is returned when the authenticated pubkey may not make the request
+	ErrForbidden = errkind.New(errkind.Forbidden, "forbidden")
+)
+
+// HTTPHandler handles the requests of an HTTP API
+type HTTPHandler func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (Response, error)
+
+type pubkeyKey struct{}
+
+// PubkeyFromContext returns the pubkey that NostrAuth authenticated the request with
+func PubkeyFromContext(ctx context.Context) (string, bool) {
+	pubkey, ok := ctx.Value(pubkeyKey{}).(string)
+	return pubkey, ok
+}
+
+// NostrAuth is middleware authenticating requests with NIP-98 HTTP auth
+type NostrAuth struct {
+	responder ProxyResponder
+	url       string
+	// pubkeys are the pubkeys that may make requests, any pubkey may when it is nil
+	pubkeys map[string]bool
+	now     func() time.Time
+}
+
+// NewNostrAuth creates the middleware. The authorization events must be for url,
+// or for https://{domain}{path} of the request when url is empty.
+func NewNostrAuth(responder ProxyResponder, url string) NostrAuth {
+	return NostrAuth{
+		responder: responder,
+		url:       url,
+		now:       time.Now,
+	}
+}
+
+// WithPubkeys returns middleware that only lets pubkeys make requests
+func (a NostrAuth) WithPubkeys(pubkeys []string) NostrAuth {
+	a.pubkeys = make(map[string]bool, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		a.pubkeys[pubkey] = true
+	}
+	return a
+}
+
+// Wrap returns a handler that calls next with the authenticated pubkey on the
+// context, responds with 401 Unauthorized to requests that are not authenticated,
+// and with 403 Forbidden to requests of pubkeys that may not make them
+func (a NostrAuth) Wrap(next HTTPHandler) HTTPHandler {
+	return func(ctx context.Context, request events.APIGatewayV2HTTPRequest) (Response, error) {
+		pubkey, err := a.authenticate(request)
+		if err != nil {
+			response := a.responder.WithError(err)
+			response.Headers["WWW-Authenticate"] = strings.TrimSpace(nostrAuthScheme)
+			return response, nil
+		}
+		if a.pubkeys != nil && !a.pubkeys[pubkey] {
+			return a.responder.WithError(fmt.Errorf("%w: pubkey %s may not make this request", ErrForbidden, pubkey)), nil
+		}
+		return next(context.WithValue(ctx, pubkeyKey{}, pubkey), request)
+	}
+}
+
+// authEvent is the authorization event
+type authEvent nostr.Event
+
+func (a NostrAuth) authenticate(request events.APIGatewayV2HTTPRequest) (string, error) {
+	encoded, found := strings.CutPrefix(request.Headers["authorization"], nostrAuthScheme)
+	if !found {
+		return "", fmt.Errorf("%w: expected the Nostr authorization scheme", ErrUnauthorized)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("%w: event is not base64 encoded", ErrUnauthorized)
+	}
+	var e authEvent
+	if err := json.Unmarshal(decoded, &e); err != nil {
+		return "", fmt.Errorf("%w: event could not be parsed", ErrUnauthorized)
+	}
+	if err := nostr.Event(e).Verify(); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if e.Kind != KindHTTPAuth {
+		return "", fmt.Errorf("%w: expected kind %d", ErrUnauthorized, KindHTTPAuth)
+	}
+	now := a.now()
+	createdAt := time.Unix(e.CreatedAt, 0)
+	if createdAt.Before(now.Add(-nostrAuthWindow)) || createdAt.After(now.Add(nostrAuthWindow)) {
+		return "", fmt.Errorf("%w: event is not created within %s of now", ErrUnauthorized, nostrAuthWindow)
+	}
+	if e.tagValue("u") != a.requestURL(request) {
+		return "", fmt.Errorf("%w: event is for another url", ErrUnauthorized)
+	}
+	if !strings.EqualFold(e.tagValue("method"), request.RequestContext.HTTP.Method) {
+		return "", fmt.Errorf("%w: event is for another method", ErrUnauthorized)
+	}
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		if body, err = base64.StdEncoding.DecodeString(request.Body); err != nil {
+			return "", fmt.Errorf("%w: body is not base64 encoded", ErrUnauthorized)
+		}
+	}
+	// the payload tag is optional in NIP-98, but a body that is not covered by the signature could be replaced
+	payload := e.tagValue("payload")
+	if payload != "" || len(body) > 0 {
+		hash := sha256.Sum256(body)
+		if !strings.EqualFold(payload, hex.EncodeToString(hash[:])) {
+			return "", fmt.Errorf("%w: payload does not match the body", ErrUnauthorized)
+		}
+	}
+	return e.PubKey, nil
+}
+
+func (a NostrAuth) requestURL(request events.APIGatewayV2HTTPRequest) string {
+	if a.url != "" {
+		return a.url
+	}
+	url := "https://" + request.RequestContext.DomainName + request.RawPath
+	if request.RawQueryString != "" {
+		url += "?" + request.RawQueryString
+	}
+	return url
+}
+
+func (e authEvent) tagValue(name string) string {
+	for _, tag := range e.Tags {
+		if len(tag) > 1 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package apigateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestNostrAuth(t *testing.T) {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkey := hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+	now := time.Unix(1700000000, 0)
+	body := `{"method":"supportedmethods","params":[]}`
+	payload := sha256.Sum256([]byte(body))
+	validTags := [][]string{{"u", "https://relay.example.com/"}, {"method", "POST"}, {"payload", hex.EncodeToString(payload[:])}}
+	authorization := func(kind int, createdAt time.Time, tags [][]string) string {
+		return signedAuthorization(t, key, authEvent{CreatedAt: createdAt.Unix(), Kind: kind, Tags: tags}, nil)
+	}
+	cases := map[string]struct {
+		authorization string
+		method        string
+		body          string
+		pubkeys       []string
+		wantStatus    int
+	}{
+		"valid": {
+			authorization: authorization(KindHTTPAuth, now, validTags),
+			wantStatus:    http.StatusOK,
+		},
+		"allowed pubkey": {
+			authorization: authorization(KindHTTPAuth, now, validTags),
+			pubkeys:       []string{pubkey},
+			wantStatus:    http.StatusOK,
+		},
+		"other pubkey": {
+			authorization: authorization(KindHTTPAuth, now, validTags),
+			pubkeys:       []string{strings.Repeat("0", 64)},
+			wantStatus:    http.StatusForbidden,
+		},
+		"no pubkeys": {
+			authorization: authorization(KindHTTPAuth, now, validTags),
+			pubkeys:       []string{},
+			wantStatus:    http.StatusForbidden,
+		},
+		"missing": {
+			wantStatus: http.StatusUnauthorized,
+		},
+		"other scheme": {
+			authorization: "Bearer token",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"not base64": {
+			authorization: "Nostr !!",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"bad signature": {
+			authorization: signedAuthorization(t, key, authEvent{CreatedAt: now.Unix(), Kind: KindHTTPAuth, Tags: validTags}, func(e *authEvent) {
+				e.Sig = e.Sig[64:] + e.Sig[:64]
+			}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		"other kind": {
+			authorization: authorization(1, now, validTags),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"expired": {
+			authorization: authorization(KindHTTPAuth, now.Add(-61*time.Second), validTags),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"other url": {
+			authorization: authorization(KindHTTPAuth, now, [][]string{{"u", "https://other.example.com/"}, validTags[1], validTags[2]}),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"other method": {
+			authorization: authorization(KindHTTPAuth, now, validTags),
+			method:        "PUT",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"changed body": {
+			authorization: authorization(KindHTTPAuth, now, validTags),
+			body:          `{}`,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		"missing payload": {
+			authorization: authorization(KindHTTPAuth, now, validTags[:2]),
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			responder := NewProxyResponder("")
+			auth := NewNostrAuth(responder, "")
+			auth.now = func() time.Time { return now }
+			if testCase.pubkeys != nil {
+				auth = auth.WithPubkeys(testCase.pubkeys)
+			}
+			var gotPubkey string
+			handler := auth.Wrap(func(ctx context.Context, _ events.APIGatewayV2HTTPRequest) (Response, error) {
+				gotPubkey, _ = PubkeyFromContext(ctx)
+				return responder.WithStatus(http.StatusOK), nil
+			})
+			request := events.APIGatewayV2HTTPRequest{
+				RawPath: "/",
+				Headers: map[string]string{"authorization": testCase.authorization},
+				Body:    body,
+				RequestContext: events.APIGatewayV2HTTPRequestContext{
+					DomainName: "relay.example.com",
+					HTTP:       events.APIGatewayV2HTTPRequestContextHTTPDescription{Method: "POST"},
+				},
+			}
+			if testCase.method != "" {
+				request.RequestContext.HTTP.Method = testCase.method
+			}
+			if testCase.body != "" {
+				request.Body = testCase.body
+			}
+
+			response, err := handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "status", response.StatusCode, testCase.wantStatus)
+			switch testCase.wantStatus {
+			case http.StatusOK:
+				verify.Values(t, "pubkey", gotPubkey, pubkey)
+			case http.StatusUnauthorized:
+				verify.Values(t, "challenge", response.Headers["WWW-Authenticate"], "Nostr")
+			}
+		})
+	}
+}
+
+/// Helper Functions ///
+
+// signedAuthorization signs the event, tampers with it when tamper is not nil,
+// and returns it as Authorization header value
+func signedAuthorization(t *testing.T, key *btcec.PrivateKey, e authEvent, tamper func(e *authEvent)) string {
+	e.PubKey = hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+	serialized, err := json.Marshal([]interface{}{0, e.PubKey, e.CreatedAt, e.Kind, e.Tags, e.Content})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(serialized)
+	e.ID = hex.EncodeToString(hash[:])
+	sig, err := schnorr.Sign(key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Sig = hex.EncodeToString(sig.Serialize())
+	if tamper != nil {
+		tamper(&e)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nostrAuthScheme + base64.StdEncoding.EncodeToString(b)
+}
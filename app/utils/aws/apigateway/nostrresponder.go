@@ -0,0 +1,129 @@
+package apigateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+// MaxFrameSize is the largest websocket frame that API Gateway sends to a client
+const MaxFrameSize = 128 * 1024
+
+// The message types a relay sends, see NIP-01, NIP-42 and NIP-45
+const (
+	MessageEvent  = "EVENT"
+	MessageOK     = "OK"
+	MessageEOSE   = "EOSE"
+	MessageClosed = "CLOSED"
+	MessageNotice = "NOTICE"
+	MessageAuth   = "AUTH"
+	MessageCount  = "COUNT"
+)
+
+// ErrFrameTooLarge is returned for messages that do not fit in a websocket frame
+var ErrFrameTooLarge = errkind.New(errkind.Validation, "message does not fit in a websocket frame")
+
+// Sender posts data to a websocket connection, like ConnectionPoster
+type Sender interface {
+	Post(ctx context.Context, connectionID string, data []byte) error
+}
+
+// EncodeMessage encodes the relay message as a JSON array, without escaping
+// HTML as the content of events must not change. It returns ErrFrameTooLarge
+// when the message does not fit in a websocket frame.
+func EncodeMessage(messageType string, elements ...interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(append([]interface{}{messageType}, elements...)); err != nil {
+		return nil, err
+	}
+	msg := bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+	if len(msg) > MaxFrameSize {
+		return nil, fmt.Errorf("%w: %s message of %d bytes", ErrFrameTooLarge, messageType, len(msg))
+	}
+	return msg, nil
+}
+
+// NostrResponder sends relay messages to a websocket connection, each in its
+// own frame as clients expect one message per frame. It collects the errors of
+// the sends, so a handler can send all its messages and check Err once.
+type NostrResponder struct {
+	ctx          context.Context
+	sender       Sender
+	connectionID string
+	sent         int
+	errs         []error
+}
+
+// NewNostrResponder creates a responder sending to the connection
+func NewNostrResponder(ctx context.Context, sender Sender, connectionID string) *NostrResponder {
+	return &NostrResponder{
+		ctx:          ctx,
+		sender:       sender,
+		connectionID: connectionID,
+	}
+}
+
+// Event sends an event of the subscription
+func (r *NostrResponder) Event(subscriptionID string, event interface{}) error {
+	return r.send(MessageEvent, subscriptionID, event)
+}
+
+// OK tells if an event was accepted, the message is empty or starts with a NIP-01 prefix
+func (r *NostrResponder) OK(eventID string, accepted bool, message string) error {
+	return r.send(MessageOK, eventID, accepted, message)
+}
+
+// EOSE marks the end of the stored events of the subscription
+func (r *NostrResponder) EOSE(subscriptionID string) error {
+	return r.send(MessageEOSE, subscriptionID)
+}
+
+// Closed tells that the relay ended the subscription
+func (r *NostrResponder) Closed(subscriptionID, message string) error {
+	return r.send(MessageClosed, subscriptionID, message)
+}
+
+// Notice sends a human readable message
+func (r *NostrResponder) Notice(message string) error {
+	return r.send(MessageNotice, message)
+}
+
+// Auth sends the challenge to authenticate with, see NIP-42
+func (r *NostrResponder) Auth(challenge string) error {
+	return r.send(MessageAuth, challenge)
+}
+
+// Count sends the number of events matching the subscription, see NIP-45
+func (r *NostrResponder) Count(subscriptionID string, count int) error {
+	return r.send(MessageCount, subscriptionID, map[string]int{"count": count})
+}
+
+// Sent returns the number of bytes sent
+func (r *NostrResponder) Sent() int {
+	return r.sent
+}
+
+// Err returns the errors of all sends that failed, or nil
+func (r *NostrResponder) Err() error {
+	return errors.Join(r.errs...)
+}
+
+func (r *NostrResponder) send(messageType string, elements ...interface{}) error {
+	msg, err := EncodeMessage(messageType, elements...)
+	if err == nil {
+		err = r.sender.Post(r.ctx, r.connectionID, msg)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to send %s to %s: %w", messageType, r.connectionID, err)
+		r.errs = append(r.errs, err)
+		return err
+	}
+	r.sent += len(msg)
+	return nil
+}
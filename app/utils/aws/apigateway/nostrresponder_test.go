@@ -0,0 +1,94 @@
+package apigateway
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestNostrResponder(t *testing.T) {
+	errPost := errors.New("post failed")
+	cases := map[string]struct {
+		send     func(r *NostrResponder) error
+		postErr  error
+		want     []string
+		wantErrs []error
+	}{
+		"event without escaping": {
+			send: func(r *NostrResponder) error {
+				return r.Event("sub", map[string]string{"content": "<b>&</b>"})
+			},
+			want: []string{`["EVENT","sub",{"content":"<b>&</b>"}]`},
+		},
+		"ok": {
+			send: func(r *NostrResponder) error { return r.OK("id", false, "blocked: pubkey is banned") },
+			want: []string{`["OK","id",false,"blocked: pubkey is banned"]`},
+		},
+		"end of stored events and closed": {
+			send: func(r *NostrResponder) error {
+				return errors.Join(r.EOSE("sub"), r.Closed("sub", "error: shutting down"))
+			},
+			want: []string{`["EOSE","sub"]`, `["CLOSED","sub","error: shutting down"]`},
+		},
+		"notice, auth and count": {
+			send: func(r *NostrResponder) error {
+				return errors.Join(r.Notice("hello"), r.Auth("challenge"), r.Count("sub", 3))
+			},
+			want: []string{`["NOTICE","hello"]`, `["AUTH","challenge"]`, `["COUNT","sub",{"count":3}]`},
+		},
+		"message too large for a frame": {
+			send: func(r *NostrResponder) error {
+				return errors.Join(
+					r.Event("sub", map[string]string{"content": strings.Repeat("a", MaxFrameSize)}),
+					r.EOSE("sub"),
+				)
+			},
+			want:     []string{`["EOSE","sub"]`},
+			wantErrs: []error{ErrFrameTooLarge},
+		},
+		"failed posts are collected": {
+			send: func(r *NostrResponder) error {
+				return errors.Join(r.Notice("one"), r.Notice("two"))
+			},
+			postErr:  errPost,
+			wantErrs: []error{errPost, errPost},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			sender := &fakeSender{err: testCase.postErr}
+			r := NewNostrResponder(context.Background(), sender, "con1")
+
+			sendErr := testCase.send(r)
+			verify.Values(t, "sent", sender.sent, testCase.want)
+			var errs []error
+			if err := r.Err(); err != nil {
+				errs = err.(interface{ Unwrap() []error }).Unwrap()
+			}
+			verify.Values(t, "errors", len(errs), len(testCase.wantErrs))
+			for i, want := range testCase.wantErrs {
+				verify.Values(t, "error", errors.Is(errs[i], want), true)
+			}
+			verify.Values(t, "send error", sendErr != nil, len(testCase.wantErrs) > 0)
+			verify.Values(t, "bytes sent", r.Sent(), len(strings.Join(testCase.want, "")))
+		})
+	}
+}
+
+/// Helper Types ///
+
+type fakeSender struct {
+	err  error
+	sent []string
+}
+
+func (s *fakeSender) Post(_ context.Context, _ string, data []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, string(data))
+	return nil
+}
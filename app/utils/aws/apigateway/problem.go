@@ -0,0 +1,91 @@
+package apigateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+// ContentTypeProblem is the content type of problem details, see RFC 7807
+const ContentTypeProblem = "application/problem+json"
+
+// Problem is the body of error responses, see RFC 7807
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// statuses are the HTTP statuses of the kinds of errors
+var statuses = map[errkind.Kind]int{
+	errkind.Validation:   http.StatusBadRequest,
+	errkind.NotFound:     http.StatusNotFound,
+	errkind.Conflict:     http.StatusConflict,
+	errkind.RateLimited:  http.StatusTooManyRequests,
+	errkind.Unauthorized: http.StatusUnauthorized,
+	errkind.Forbidden:    http.StatusForbidden,
+	errkind.Unavailable:  http.StatusServiceUnavailable,
+}
+
+// nostrPrefixes are the machine-readable prefixes of OK and CLOSED messages for the kinds of errors, see NIP-01
+var nostrPrefixes = map[errkind.Kind]string{
+	errkind.Validation:   "invalid",
+	errkind.Conflict:     "duplicate",
+	errkind.RateLimited:  "rate-limited",
+	errkind.Unauthorized: "blocked",
+	errkind.Forbidden:    "restricted",
+}
+
+// StatusOf returns the HTTP status for the kind of the error
+func StatusOf(err error) int {
+	if status, found := statuses[errkind.Of(err)]; found {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// WithError creates a response with the status for the kind of the error, and
+// its problem details. Errors that are not classified are logged, and their
+// message is not shown to clients.
+func (r ProxyResponder) WithError(err error) Response {
+	status := StatusOf(err)
+	problem := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+	if errkind.Of(err) == errkind.Internal {
+		log.WithError(err).Error("internal error")
+	} else {
+		problem.Detail = err.Error()
+	}
+	body, err := json.Marshal(problem)
+	if err != nil {
+		panic(err) // preserve the fluent interface, this should never happen
+	}
+	return r.WithStatus(status).WithBody(string(body), ContentTypeProblem)
+}
+
+// NostrReason returns the message of an OK or CLOSED message for the error,
+// starting with the NIP-01 prefix for its kind. Errors that are not classified
+// are not shown to clients, the message says what failed instead.
+func NostrReason(err error, failed string) string {
+	kind := errkind.Of(err)
+	if kind == errkind.Internal {
+		return "error: " + failed
+	}
+	prefix, found := nostrPrefixes[kind]
+	if !found {
+		prefix = "error"
+	}
+	message := err.Error()
+	if strings.HasPrefix(message, prefix+":") {
+		return message
+	}
+	return prefix + ": " + message
+}
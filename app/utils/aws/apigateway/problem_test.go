@@ -0,0 +1,86 @@
+package apigateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+func TestWithError(t *testing.T) {
+	cases := map[string]struct {
+		err         error
+		wantProblem Problem
+	}{
+		"validation": {
+			err:         fmt.Errorf("%w: limit is not a number", errkind.New(errkind.Validation, "invalid query")),
+			wantProblem: Problem{Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest, Detail: "invalid query: limit is not a number"},
+		},
+		"not found": {
+			err:         ErrGone,
+			wantProblem: Problem{Type: "about:blank", Title: "Not Found", Status: http.StatusNotFound, Detail: "connection is gone"},
+		},
+		"rate limited": {
+			err:         errkind.New(errkind.RateLimited, "slow down"),
+			wantProblem: Problem{Type: "about:blank", Title: "Too Many Requests", Status: http.StatusTooManyRequests, Detail: "slow down"},
+		},
+		"forbidden": {
+			err:         fmt.Errorf("%w: not an admin", ErrForbidden),
+			wantProblem: Problem{Type: "about:blank", Title: "Forbidden", Status: http.StatusForbidden, Detail: "forbidden: not an admin"},
+		},
+		"internal errors are not shown": {
+			err:         errors.New("connection refused by 10.0.0.1"),
+			wantProblem: Problem{Type: "about:blank", Title: "Internal Server Error", Status: http.StatusInternalServerError},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			response := NewProxyResponder("*").WithError(testCase.err)
+			var problem Problem
+			if err := json.Unmarshal([]byte(response.Body), &problem); err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "status", response.StatusCode, testCase.wantProblem.Status)
+			verify.Values(t, "content type", response.Headers["Content-Type"], ContentTypeProblem)
+			verify.Values(t, "problem", problem, testCase.wantProblem)
+		})
+	}
+}
+
+func TestNostrReason(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want string
+	}{
+		"message with the prefix": {
+			err:  errkind.New(errkind.Validation, "invalid: event id does not match"),
+			want: "invalid: event id does not match",
+		},
+		"wrapped message with the prefix": {
+			err:  fmt.Errorf("%w: pubkey is banned", errkind.New(errkind.Unauthorized, "blocked")),
+			want: "blocked: pubkey is banned",
+		},
+		"message without the prefix": {
+			err:  errkind.New(errkind.RateLimited, "too many events"),
+			want: "rate-limited: too many events",
+		},
+		"kind without a prefix": {
+			err:  ErrThrottled,
+			want: "error: posting to connection is throttled",
+		},
+		"internal error": {
+			err:  errors.New("connection refused by 10.0.0.1"),
+			want: "error: could not store event",
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			verify.Values(t, "reason", NostrReason(testCase.err, "could not store event"), testCase.want)
+		})
+	}
+}
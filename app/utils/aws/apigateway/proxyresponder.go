@@ -4,11 +4,10 @@ Package apigateway contains proxy responder to easily create proxy responses
 package apigateway
 
 import (
-	"bytes"
-	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	log "github.com/sirupsen/logrus"
@@ -19,6 +18,7 @@ const minCompressSize = 1024
 // ProxyResponder is the responder that gives API Gateway Proxy responses
 type ProxyResponder struct {
 	originAllowed string
+	cors          *CORS
 }
 
 // NewProxyResponder creates a new proxy responder
@@ -37,6 +37,9 @@ func (r ProxyResponder) WithStatus(statusCode int) Response {
 	if r.originAllowed != "" {
 		response.Headers["Access-Control-Allow-Origin"] = r.originAllowed
 	}
+	if r.cors != nil && len(r.cors.ExposedHeaders) > 0 {
+		response.Headers["Access-Control-Expose-Headers"] = strings.Join(r.cors.ExposedHeaders, ", ")
+	}
 	return response
 }
 
@@ -76,21 +79,40 @@ func (r Response) WithBody(body string, contentType string) Response {
 	return r
 }
 
+// WithGzip gzips the body when it is large enough, whether the client accepts it or not.
+// See WithCompression to negotiate the encoding with the client.
 func (r Response) WithGzip() Response {
-	if len([]byte(r.Body)) < minCompressSize {
+	return r.compress(encodingGzip)
+}
+
+// WithCompression compresses the body with the encoding that the Accept-Encoding
+// header of the request prefers, of brotli, zstd and gzip. Bodies that are small,
+// or of a content type that is already compressed, are not compressed.
+func (r Response) WithCompression(requestHeaders map[string]string) Response {
+	r.Headers["Vary"] = addToList(r.Headers["Vary"], "Accept-Encoding")
+	if isCompressedContentType(r.Headers["Content-Type"]) {
+		return r
+	}
+	encoding := negotiateEncoding(headerValue(requestHeaders, "Accept-Encoding"))
+	if encoding == "" {
 		return r
 	}
-	var b bytes.Buffer
-	w := gzip.NewWriter(&b)
-	if _, err := w.Write([]byte(r.Body)); err != nil {
-		log.WithError(err).Error("failed to compress gzipped body")
+	return r.compress(encoding)
+}
+
+func (r Response) compress(encoding string) Response {
+	if len([]byte(r.Body)) < minCompressSize || r.Headers["Content-Encoding"] != "" {
+		return r
+	}
+	compressed, err := encoders[encoding]([]byte(r.Body))
+	if err != nil {
+		log.WithError(err).Errorf("failed to compress body with %s", encoding)
 		r.StatusCode = http.StatusInternalServerError
 		return r
 	}
-	_ = w.Close()
-	r.Body = base64.StdEncoding.EncodeToString(b.Bytes())
+	r.Body = base64.StdEncoding.EncodeToString(compressed)
 	r.IsBase64Encoded = true
-	r.Headers["Content-Encoding"] = "gzip"
+	r.Headers["Content-Encoding"] = encoding
 	delete(r.Headers, "Content-Length")
 	return r
 }
@@ -1,13 +1,19 @@
 package apigateway
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pascaldekloe/goe/verify"
 )
 
@@ -85,6 +91,82 @@ func TestProxyResponderWithJSONBodyGzip(t *testing.T) {
 	verify.Values(t, "body", response.Body, wantDecodedBody)
 }
 
+func TestProxyResponderWithCompression(t *testing.T) {
+	longBody := strings.Repeat("compress me ", 100)
+	cases := map[string]struct {
+		acceptEncoding string
+		body           string
+		contentType    string
+		wantEncoding   string
+	}{
+		"no accept-encoding": {
+			body:        longBody,
+			contentType: "text/plain",
+		},
+		"gzip": {
+			acceptEncoding: "gzip",
+			body:           longBody,
+			contentType:    "text/plain",
+			wantEncoding:   "gzip",
+		},
+		"brotli is preferred on equal q-values": {
+			acceptEncoding: "gzip, deflate, br, zstd",
+			body:           longBody,
+			contentType:    "text/plain",
+			wantEncoding:   "br",
+		},
+		"highest q-value": {
+			acceptEncoding: "br;q=0.5, zstd;q=0.9, gzip;q=0.8",
+			body:           longBody,
+			contentType:    "text/plain",
+			wantEncoding:   "zstd",
+		},
+		"refused with q=0": {
+			acceptEncoding: "br;q=0, gzip",
+			body:           longBody,
+			contentType:    "text/plain",
+			wantEncoding:   "gzip",
+		},
+		"wildcard": {
+			acceptEncoding: "*",
+			body:           longBody,
+			contentType:    "text/plain",
+			wantEncoding:   "br",
+		},
+		"identity is preferred": {
+			acceptEncoding: "gzip;q=0.5, identity",
+			body:           longBody,
+			contentType:    "text/plain",
+		},
+		"unsupported encoding": {
+			acceptEncoding: "deflate",
+			body:           longBody,
+			contentType:    "text/plain",
+		},
+		"small body": {
+			acceptEncoding: "gzip",
+			body:           "small",
+			contentType:    "text/plain",
+		},
+		"compressed content type": {
+			acceptEncoding: "gzip",
+			body:           longBody,
+			contentType:    "image/png",
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			response := NewProxyResponder("*").WithStatus(http.StatusOK).
+				WithBody(testCase.body, testCase.contentType).
+				WithCompression(map[string]string{"accept-encoding": testCase.acceptEncoding})
+			verify.Values(t, "vary", response.Headers["Vary"], "Accept-Encoding")
+			verify.Values(t, "encoding", response.Headers["Content-Encoding"], testCase.wantEncoding)
+			verify.Values(t, "base64", response.IsBase64Encoded, testCase.wantEncoding != "")
+			verify.Values(t, "body", decodeBody(t, response), testCase.body)
+		})
+	}
+}
+
 func TestProxyResponderWithPlainTextBody(t *testing.T) {
 	t.Log("given a ProxyResponder")
 	proxyResponder := NewProxyResponder("*")
@@ -184,6 +266,37 @@ func expectBody(t *testing.T, bytes []byte, body string) {
 	}
 }
 
+func decodeBody(t *testing.T, response Response) string {
+	if !response.IsBase64Encoded {
+		return response.Body
+	}
+	compressed, err := base64.StdEncoding.DecodeString(response.Body)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	var r io.Reader
+	switch response.Headers["Content-Encoding"] {
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(compressed))
+	case "zstd":
+		decoder, err := zstd.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("did not expect error %v", err)
+		}
+		defer decoder.Close()
+		r = decoder
+	case "gzip":
+		if r, err = gzip.NewReader(bytes.NewReader(compressed)); err != nil {
+			t.Fatalf("did not expect error %v", err)
+		}
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	return string(body)
+}
+
 func expectNoHeader(t *testing.T, bytes []byte, header string) {
 	var result struct {
 		Headers map[string]string `json:"headers"`
@@ -0,0 +1,99 @@
+/*
+Package metrics writes CloudWatch metrics as log lines in the embedded metric
+format, which CloudWatch extracts from the logs of Lambda functions
+*/
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Unit is the unit of a metric
+type Unit string
+
+const (
+	UnitCount        Unit = "Count"
+	UnitMilliseconds Unit = "Milliseconds"
+)
+
+// Metric is a single metric value
+type Metric struct {
+	Name  string
+	Value float64
+	Unit  Unit
+}
+
+// Logger writes metrics in the embedded metric format
+type Logger struct {
+	namespace  string
+	dimensions map[string]string
+	w          io.Writer
+	now        func() time.Time
+}
+
+// NewLogger creates a logger writing the metrics to stdout, in the namespace and with the dimensions
+func NewLogger(namespace string, dimensions map[string]string, opts ...func(l *Logger)) Logger {
+	l := Logger{
+		namespace:  namespace,
+		dimensions: dimensions,
+		w:          os.Stdout,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	return l
+}
+
+// WithWriter sets where the metrics are written to
+func WithWriter(w io.Writer) func(l *Logger) {
+	return func(l *Logger) {
+		l.w = w
+	}
+}
+
+type metricDefinition struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit"`
+}
+
+type metricDirective struct {
+	Namespace  string             `json:"Namespace"`
+	Dimensions [][]string         `json:"Dimensions"`
+	Metrics    []metricDefinition `json:"Metrics"`
+}
+
+type metadata struct {
+	Timestamp         int64             `json:"Timestamp"`
+	CloudWatchMetrics []metricDirective `json:"CloudWatchMetrics"`
+}
+
+// Put writes the metrics as a single log line
+func (l Logger) Put(metrics ...Metric) error {
+	dimensionNames := make([]string, 0, len(l.dimensions))
+	for name := range l.dimensions {
+		dimensionNames = append(dimensionNames, name)
+	}
+	sort.Strings(dimensionNames)
+	directive := metricDirective{
+		Namespace:  l.namespace,
+		Dimensions: [][]string{dimensionNames},
+	}
+	line := map[string]interface{}{}
+	for name, value := range l.dimensions {
+		line[name] = value
+	}
+	for _, m := range metrics {
+		directive.Metrics = append(directive.Metrics, metricDefinition{Name: m.Name, Unit: m.Unit})
+		line[m.Name] = m.Value
+	}
+	line["_aws"] = metadata{
+		Timestamp:         l.now().UnixMilli(),
+		CloudWatchMetrics: []metricDirective{directive},
+	}
+	return json.NewEncoder(l.w).Encode(line)
+}
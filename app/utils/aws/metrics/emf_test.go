@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestPut(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewLogger("NostrAppData", map[string]string{"Function": "reaper", "Stage": "test"}, WithWriter(&out))
+	logger.now = func() time.Time { return time.UnixMilli(1700000000000) }
+
+	err := logger.Put(Metric{Name: "Removed", Value: 3, Unit: UnitCount}, Metric{Name: "Checked", Value: 5, Unit: UnitCount})
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "line", out.String(), `{"Checked":5,"Function":"reaper","Removed":3,"Stage":"test","_aws":{"Timestamp":1700000000000,`+
+		`"CloudWatchMetrics":[{"Namespace":"NostrAppData","Dimensions":[["Function","Stage"]],"Metrics":[{"Name":"Removed","Unit":"Count"},{"Name":"Checked","Unit":"Count"}]}]}}`+"\n")
+}
@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// MemoryQueue is an in-memory queue that redelivers failed messages, and moves
+// them to its dead-letter queue after maxReceives attempts, like an SQS queue
+// with a redrive policy
+type MemoryQueue struct {
+	maxReceives int
+
+	mu         sync.Mutex
+	nextID     int
+	pending    []memoryMessage
+	deadLetter []string
+}
+
+type memoryMessage struct {
+	id       string
+	body     string
+	receives int
+}
+
+// NewMemoryQueue creates an in-memory queue that dead-letters messages after maxReceives attempts
+func NewMemoryQueue(maxReceives int) *MemoryQueue {
+	return &MemoryQueue{maxReceives: maxReceives}
+}
+
+// Send adds the messages to the queue
+func (q *MemoryQueue) Send(_ context.Context, bodies ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, body := range bodies {
+		q.nextID++
+		q.pending = append(q.pending, memoryMessage{id: strconv.Itoa(q.nextID), body: body})
+	}
+	return nil
+}
+
+// Drain passes the messages to handle in batches, as the Lambda event source
+// mapping does, until the queue is empty. Messages reported as failed, or in a
+// batch for which handle returned an error, are retried until they are moved
+// to the dead-letter queue.
+func (q *MemoryQueue) Drain(ctx context.Context, batchSize int, handle Handler) error {
+	for {
+		batch := q.receive(batchSize)
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		event := events.SQSEvent{Records: make([]events.SQSMessage, len(batch))}
+		for i, m := range batch {
+			event.Records[i] = events.SQSMessage{
+				MessageId:  m.id,
+				Body:       m.body,
+				Attributes: map[string]string{"ApproximateReceiveCount": strconv.Itoa(m.receives)},
+			}
+		}
+		response, err := handle(ctx, event)
+		failed := map[string]bool{}
+		for _, failure := range response.BatchItemFailures {
+			failed[failure.ItemIdentifier] = true
+		}
+		var retry []memoryMessage
+		for _, m := range batch {
+			if err != nil || failed[m.id] {
+				retry = append(retry, m)
+			}
+		}
+		q.requeue(retry)
+	}
+}
+
+// DeadLetters returns the bodies of the messages moved to the dead-letter queue
+func (q *MemoryQueue) DeadLetters() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.deadLetter...)
+}
+
+// Len returns the number of messages waiting to be received
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *MemoryQueue) receive(batchSize int) []memoryMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := min(batchSize, len(q.pending))
+	batch := make([]memoryMessage, n)
+	copy(batch, q.pending[:n])
+	q.pending = q.pending[n:]
+	for i := range batch {
+		batch[i].receives++
+	}
+	return batch
+}
+
+func (q *MemoryQueue) requeue(messages []memoryMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range messages {
+		if m.receives >= q.maxReceives {
+			q.deadLetter = append(q.deadLetter, m.body)
+			continue
+		}
+		q.pending = append(q.pending, m)
+	}
+}
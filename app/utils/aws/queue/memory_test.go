@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestMemoryQueueDrain(t *testing.T) {
+	cases := map[string]struct {
+		failing    map[string]int
+		handlerErr error
+		wantCalls  int
+		wantDead   []string
+	}{
+		"all succeed": {
+			wantCalls: 2,
+		},
+		"retried until it succeeds": {
+			failing:   map[string]int{"b": 2},
+			wantCalls: 3,
+		},
+		"dead-lettered after max receives": {
+			failing:   map[string]int{"b": 5},
+			wantCalls: 3,
+			wantDead:  []string{"b"},
+		},
+		"handler errors fail the whole batch": {
+			handlerErr: errors.New("failed"),
+			wantCalls:  5,
+			wantDead:   []string{"a", "b", "c"},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			q := NewMemoryQueue(3)
+			if err := q.Send(context.Background(), "a", "b", "c"); err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			calls := 0
+			err := q.Drain(context.Background(), 2, func(_ context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+				calls++
+				var response events.SQSEventResponse
+				for _, record := range event.Records {
+					if testCase.failing[record.Body] > 0 {
+						testCase.failing[record.Body]--
+						response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+					}
+				}
+				return response, testCase.handlerErr
+			})
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "calls", calls, testCase.wantCalls)
+			verify.Values(t, "dead letters", q.DeadLetters(), testCase.wantDead)
+			verify.Values(t, "pending", q.Len(), 0)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+/*
+Package queue sends messages to be processed asynchronously, through SQS or an
+in-memory queue that behaves like SQS with a redrive policy for local use and tests
+*/
+package queue
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxBatchSize is the most messages SQS accepts in a single batch
+const maxBatchSize = 10
+
+// Queue sends messages to be processed asynchronously
+type Queue interface {
+	Send(ctx context.Context, bodies ...string) error
+}
+
+// Handler handles a batch of messages the way an SQS triggered Lambda function
+// does, returning the messages that failed as batch item failures
+type Handler func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error)
@@ -0,0 +1,53 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+type sqsQueue struct {
+	client   *sqs.SQS
+	queueURL string
+}
+
+// NewSQSQueue creates a queue sending to the SQS queue with the URL
+func NewSQSQueue(queueURL string) Queue {
+	var sess *session.Session
+	if _, ok := os.LookupEnv("AWS_REGION"); ok {
+		sess = session.Must(session.NewSession())
+	} else {
+		sess = session.Must(session.NewSession(&aws.Config{Region: aws.String("eu-west-1")}))
+	}
+	return &sqsQueue{client: sqs.New(sess), queueURL: queueURL}
+}
+
+// Send sends the messages in batches, and fails when any of them was not sent
+func (q *sqsQueue) Send(ctx context.Context, bodies ...string) error {
+	for start := 0; start < len(bodies); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(bodies))
+		entries := make([]*sqs.SendMessageBatchRequestEntry, 0, end-start)
+		for i, body := range bodies[start:end] {
+			entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(body),
+			})
+		}
+		output, err := q.client.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(q.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send messages: %w", err)
+		}
+		if len(output.Failed) > 0 {
+			return fmt.Errorf("failed to send %d of %d messages: %s", len(output.Failed), len(entries), aws.StringValue(output.Failed[0].Message))
+		}
+	}
+	return nil
+}
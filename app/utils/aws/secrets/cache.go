@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultTTL = 5 * time.Minute
+
+// CachingService caches secret values for a TTL, so warm Lambda invocations
+// don't call Secrets Manager each time. When credentials stop working, for
+// instance after a rotation, RefreshOnAuthFailure reloads the secret and
+// notifies the rotation hooks when it has another version than the credentials.
+type CachingService struct {
+	inner        VersionedService
+	ttl          time.Duration
+	versionStage string
+	now          func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+	hooks   []func(secretName string)
+}
+
+type cacheKey struct {
+	secretName   string
+	versionStage string
+}
+
+type cacheEntry struct {
+	version   Version
+	expiresAt time.Time
+}
+
+// NewCachingService wraps the service with a cache
+func NewCachingService(inner VersionedService, opts ...func(s *CachingService)) *CachingService {
+	s := &CachingService{
+		inner:        inner,
+		ttl:          defaultTTL,
+		versionStage: StageCurrent,
+		now:          time.Now,
+		entries:      map[cacheKey]cacheEntry{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithTTL sets how long a secret value is cached
+func WithTTL(ttl time.Duration) func(s *CachingService) {
+	return func(s *CachingService) {
+		s.ttl = ttl
+	}
+}
+
+// WithVersionStage sets the version stage read by GetAndUnmarshal and friends.
+// Use AWSPENDING in a rotation function to test the new credentials.
+func WithVersionStage(versionStage string) func(s *CachingService) {
+	return func(s *CachingService) {
+		s.versionStage = versionStage
+	}
+}
+
+// OnRotation registers a hook that is called when a refresh finds a new version of a secret
+func (s *CachingService) OnRotation(hook func(secretName string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// GetVersion returns the cached version, or retrieves it when missing or expired.
+// An empty version stage means the stage of the service.
+func (s *CachingService) GetVersion(secretName string, versionStage string) (Version, error) {
+	if versionStage == "" {
+		versionStage = s.versionStage
+	}
+	key := cacheKey{secretName: secretName, versionStage: versionStage}
+	s.mu.Lock()
+	entry, found := s.entries[key]
+	s.mu.Unlock()
+	if found && s.now().Before(entry.expiresAt) {
+		return entry.version, nil
+	}
+	return s.fetch(key)
+}
+
+func (s *CachingService) fetch(key cacheKey) (Version, error) {
+	version, err := s.inner.GetVersion(key.secretName, key.versionStage)
+	if err != nil {
+		return Version{}, err
+	}
+	s.mu.Lock()
+	s.entries[key] = cacheEntry{version: version, expiresAt: s.now().Add(s.ttl)}
+	s.mu.Unlock()
+	return version, nil
+}
+
+// Invalidate removes all cached versions of the secret
+func (s *CachingService) Invalidate(secretName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if key.secretName == secretName {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// RefreshOnAuthFailure is to be called when the credentials of the version with
+// versionID were rejected. It reloads the secret, and tells if it has another
+// version now, in which case the rotation hooks are called and the caller can
+// reconnect. The cached version is not compared, as it may have been refreshed
+// since the caller read the credentials. An empty versionID counts as another version.
+func (s *CachingService) RefreshOnAuthFailure(secretName string, versionID string) (bool, error) {
+	key := cacheKey{secretName: secretName, versionStage: s.versionStage}
+	s.mu.Lock()
+	hooks := append([]func(string){}, s.hooks...)
+	s.mu.Unlock()
+
+	version, err := s.fetch(key)
+	if err != nil {
+		return false, err
+	}
+	if versionID != "" && version.ID == versionID {
+		return false, nil
+	}
+	log.WithField("secretName", secretName).WithField("versionId", version.ID).Info("secret was rotated")
+	for _, hook := range hooks {
+		hook(secretName)
+	}
+	return true, nil
+}
+
+// GetAndUnmarshal retrieves the unmarshalled value behind the secret.
+func (s *CachingService) GetAndUnmarshal(secretName string, v interface{}) error {
+	return getAndUnmarshal(s, secretName, v)
+}
+
+// GetAndUnmarshalStrict retrieves the unmarshalled value behind the secret
+// without allowing unknown fields
+func (s *CachingService) GetAndUnmarshalStrict(secretName string, v interface{}) error {
+	return getAndUnmarshalStrict(s, secretName, v)
+}
+
+// MustGetAndUnmarshal retrieves the unmarshalled value behind the secret.
+func (s *CachingService) MustGetAndUnmarshal(secretName string, v interface{}) {
+	mustGetAndUnmarshal(s, secretName, v)
+}
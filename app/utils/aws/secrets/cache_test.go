@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestCachingServiceTTL(t *testing.T) {
+	inner := &countingService{versions: map[string]Version{StageCurrent: {ID: "v1", Value: `{"a":1}`}}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	svc := NewCachingService(inner, WithTTL(time.Minute))
+	svc.now = func() time.Time { return now }
+
+	var v struct{ A int }
+	for i := 0; i < 3; i++ {
+		if err := svc.GetAndUnmarshal("secret", &v); err != nil {
+			t.Fatalf("did not expect error %v", err)
+		}
+	}
+	verify.Values(t, "calls within ttl", inner.calls, 1)
+	verify.Values(t, "value", v.A, 1)
+
+	now = now.Add(2 * time.Minute)
+	_ = svc.GetAndUnmarshal("secret", &v)
+	verify.Values(t, "calls after ttl", inner.calls, 2)
+
+	svc.Invalidate("secret")
+	_ = svc.GetAndUnmarshal("secret", &v)
+	verify.Values(t, "calls after invalidate", inner.calls, 3)
+}
+
+func TestCachingServiceVersionStage(t *testing.T) {
+	inner := &countingService{versions: map[string]Version{
+		StageCurrent: {ID: "v1", Value: `{"a":1}`},
+		StagePending: {ID: "v2", Value: `{"a":2}`},
+	}}
+	svc := NewCachingService(inner, WithVersionStage(StagePending))
+
+	var v struct{ A int }
+	if err := svc.GetAndUnmarshal("secret", &v); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "pending value", v.A, 2)
+
+	current, err := svc.GetVersion("secret", StageCurrent)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "current version", current.ID, "v1")
+}
+
+func TestCachingServiceRefreshOnAuthFailure(t *testing.T) {
+	inner := &countingService{versions: map[string]Version{StageCurrent: {ID: "v1", Value: `{}`}}}
+	svc := NewCachingService(inner)
+	var rotated []string
+	svc.OnRotation(func(secretName string) { rotated = append(rotated, secretName) })
+
+	connected, err := svc.GetVersion("secret", "")
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+
+	changed, err := svc.RefreshOnAuthFailure("secret", connected.ID)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "changed without rotation", changed, false)
+	verify.Values(t, "hooks without rotation", len(rotated), 0)
+
+	t.Log("when the cache is refreshed after the rotation, before the credentials are rejected")
+	inner.versions[StageCurrent] = Version{ID: "v2", Value: `{}`}
+	svc.Invalidate("secret")
+	if _, err := svc.GetVersion("secret", ""); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	changed, err = svc.RefreshOnAuthFailure("secret", connected.ID)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "changed after rotation", changed, true)
+	verify.Values(t, "hooks after rotation", rotated, []string{"secret"})
+}
+
+func TestCachingServiceRefreshOnAuthFailureWithoutEntry(t *testing.T) {
+	inner := &countingService{versions: map[string]Version{StageCurrent: {ID: "v1", Value: `{}`}}}
+	svc := NewCachingService(inner)
+
+	changed, err := svc.RefreshOnAuthFailure("secret", "v1")
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "changed", changed, false)
+}
+
+/// Helper Types ///
+
+type countingService struct {
+	VersionedService
+	versions map[string]Version
+	calls    int
+}
+
+func (s *countingService) GetVersion(_ string, versionStage string) (Version, error) {
+	s.calls++
+	return s.versions[versionStage], nil
+}
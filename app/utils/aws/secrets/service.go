@@ -11,58 +11,100 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// The version stages Secrets Manager uses during rotation
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePending  = "AWSPENDING"
+	StagePrevious = "AWSPREVIOUS"
+)
+
 type Service interface {
 	GetAndUnmarshal(secretName string, v interface{}) error
 	GetAndUnmarshalStrict(secretName string, v interface{}) error
 	MustGetAndUnmarshal(secretName string, v interface{})
 }
 
+// VersionedService reads a specific version stage of a secret
+type VersionedService interface {
+	Service
+	GetVersion(secretName string, versionStage string) (Version, error)
+}
+
+// Version is a version of a secret value
+type Version struct {
+	ID     string
+	Stages []string
+	Value  string
+}
+
 type service struct {
 	manager *secretsmanager.SecretsManager
 }
 
-func NewService() Service {
+func NewService() VersionedService {
 	return &service{manager: manager()}
 }
 
-// GetAndUnmarshal retrieves the unmarshalled value behind the secret.
-func (s *service) GetAndUnmarshal(secretName string, v interface{}) error {
-	secretValue, err := s.manager.GetSecretValue(&secretsmanager.GetSecretValueInput{
+// GetVersion retrieves the secret value with the version stage, AWSCURRENT when empty.
+func (s *service) GetVersion(secretName string, versionStage string) (Version, error) {
+	input := &secretsmanager.GetSecretValueInput{
 		SecretId: &secretName,
-	})
-	if err != nil {
-		return err
 	}
-	if err := json.Unmarshal([]byte(*secretValue.SecretString), v); err != nil {
-		return err
+	if versionStage != "" {
+		input.VersionStage = &versionStage
+	}
+	secretValue, err := s.manager.GetSecretValue(input)
+	if err != nil {
+		return Version{}, err
 	}
-	return nil
+	return Version{
+		ID:     aws.StringValue(secretValue.VersionId),
+		Stages: aws.StringValueSlice(secretValue.VersionStages),
+		Value:  aws.StringValue(secretValue.SecretString),
+	}, nil
+}
+
+// GetAndUnmarshal retrieves the unmarshalled value behind the secret.
+func (s *service) GetAndUnmarshal(secretName string, v interface{}) error {
+	return getAndUnmarshal(s, secretName, v)
 }
 
 // GetAndUnmarshalStrict retrieves the unmarshalled value behind the secret
 // without allowing unknown fields
 func (s *service) GetAndUnmarshalStrict(secretName string, v interface{}) error {
-	secretValue, err := s.manager.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId: &secretName,
-	})
+	return getAndUnmarshalStrict(s, secretName, v)
+}
+
+// MustGetAndUnmarshal retrieves the unmarshalled value behind the secret.
+func (s *service) MustGetAndUnmarshal(secretName string, v interface{}) {
+	mustGetAndUnmarshal(s, secretName, v)
+}
+
+func getAndUnmarshal(s VersionedService, secretName string, v interface{}) error {
+	version, err := s.GetVersion(secretName, "")
 	if err != nil {
 		return err
 	}
-	decoder := json.NewDecoder(strings.NewReader(*secretValue.SecretString))
+	return json.Unmarshal([]byte(version.Value), v)
+}
+
+func getAndUnmarshalStrict(s VersionedService, secretName string, v interface{}) error {
+	version, err := s.GetVersion(secretName, "")
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(version.Value))
 	decoder.DisallowUnknownFields()
 	return decoder.Decode(v)
 }
 
-// MustGetAndUnmarshal retrieves the unmarshalled value behind the secret.
-func (s *service) MustGetAndUnmarshal(secretName string, v interface{}) {
-	secretValue, err := s.manager.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId: &secretName,
-	})
+func mustGetAndUnmarshal(s VersionedService, secretName string, v interface{}) {
+	version, err := s.GetVersion(secretName, "")
 	if err != nil {
 		log.WithField("secretName", secretName).WithError(err).Error("configuration issue: problem getting secret.")
 		panic(err)
 	}
-	if err := json.Unmarshal([]byte(*secretValue.SecretString), v); err != nil {
+	if err := json.Unmarshal([]byte(version.Value), v); err != nil {
 		log.WithField("secretName", secretName).WithError(err).Error("configuration issue: problem unmarshalling secret.")
 		panic(err)
 	}
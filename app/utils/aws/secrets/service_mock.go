@@ -9,10 +9,17 @@ type mockService struct {
 	source string
 }
 
-func NewMockService(source string) Service {
+func NewMockService(source string) VersionedService {
 	return &mockService{source: source}
 }
 
+func (s *mockService) GetVersion(_ string, versionStage string) (Version, error) {
+	if versionStage == "" {
+		versionStage = StageCurrent
+	}
+	return Version{ID: "mock", Stages: []string{versionStage}, Value: s.source}, nil
+}
+
 func (s *mockService) GetAndUnmarshal(_ string, v interface{}) error {
 	return json.Unmarshal([]byte(s.source), v)
 }
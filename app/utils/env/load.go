@@ -0,0 +1,202 @@
+package env
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Load sets the fields of the struct that cfg points to from the environment
+// variables named by their env tags, as in
+//
+//	type config struct {
+//		DBSecret string        `env:"DB_SECRET,required"`
+//		Timeout  time.Duration `env:"TIMEOUT" default:"5s"`
+//		Mongo    mongoConfig   `env:"MONGO_"`
+//	}
+//
+// Fields of struct types are loaded with the env tag as a prefix to the names
+// of their variables. Durations are parsed by time.ParseDuration, or as seconds.
+// Slices are separated by comma, and maps are key:value pairs separated by comma.
+// Fields of types implementing encoding.TextUnmarshaler are unmarshaled.
+// A field keeps its value when its variable is not set and has no default.
+// All the missing and invalid variables are returned in one error. The secret
+// option, as in `env:"RELAY_PRIVATE_KEY,secret"`, redacts the value in a Report
+// and in the error.
+//
+// The values are loaded from the environment by default. Options add dotenv
+// files and overrides, the layers take precedence in the order: defaults,
+// dotenv files, the environment, and overrides.
+func Load(cfg interface{}, opts ...Option) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected a pointer to a struct, got %T", cfg)
+	}
+	l := &loader{}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return err
+		}
+	}
+	return errors.Join(l.load(v.Elem(), "")...)
+}
+
+// MustLoad loads cfg as Load does, and panics when variables are missing or invalid.
+// When CONFIG_DEBUG is true, it writes the variables and their layers to stderr.
+func MustLoad(cfg interface{}, opts ...Option) {
+	var report Report
+	err := Load(cfg, append([]Option{WithReport(&report)}, opts...)...)
+	if MustGetBoolOrDefault("CONFIG_DEBUG", false) {
+		_ = report.Dump(os.Stderr)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (l *loader) load(v reflect.Value, prefix string) []error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, tagged := field.Tag.Lookup("env")
+		options := strings.Split(tag, ",")
+		name := options[0]
+		if isNested(field.Type) {
+			errs = append(errs, l.load(v.Field(i), prefix+name)...)
+			continue
+		}
+		if !tagged || name == "" {
+			continue
+		}
+		name = prefix + name
+		secret := isSecret(name, options[1:])
+		value, layer := l.lookup(name)
+		if value == "" {
+			value, layer = field.Tag.Get("default"), LayerDefault
+		}
+		if value == "" {
+			if !v.Field(i).IsZero() {
+				l.record(name, fmt.Sprint(v.Field(i).Interface()), LayerPreset, secret)
+				continue
+			}
+			l.record(name, "", LayerUnset, secret)
+			if slices.Contains(options[1:], "required") {
+				errs = append(errs, fmt.Errorf("missing env variable: %s", name))
+			}
+			continue
+		}
+		l.record(name, value, layer, secret)
+		if err := set(v.Field(i), value); err != nil {
+			if secret {
+				// the parse errors quote the value too
+				errs = append(errs, fmt.Errorf("failed to parse %s=%s", name, redacted))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("failed to parse %s=%s: %w", name, value, err))
+		}
+	}
+	return errs
+}
+
+// isNested tells if the fields of a struct are loaded, rather than the struct itself
+func isNested(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func set(v reflect.Value, value string) error {
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := set(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range strings.Split(value, ",") {
+			key, val, found := strings.Cut(pair, ":")
+			if !found {
+				return fmt.Errorf("expected key:value, got %q", pair)
+			}
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := set(k, strings.TrimSpace(key)); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := set(e, strings.TrimSpace(val)); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+		return nil
+	}
+	return setScalar(v, value)
+}
+
+func setScalar(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, atoiErr := strconv.Atoi(value)
+			if atoiErr != nil {
+				return err
+			}
+			d = time.Duration(seconds) * time.Second
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package env
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+type testConfig struct {
+	Secret    string          `env:"TEST_SECRET,required"`
+	Timeout   time.Duration   `env:"TEST_TIMEOUT" default:"5s"`
+	Ratio     float64         `env:"TEST_RATIO"`
+	Size      int64           `env:"TEST_SIZE"`
+	Enabled   bool            `env:"TEST_ENABLED"`
+	Pubkeys   []string        `env:"TEST_PUBKEYS"`
+	Kinds     []int           `env:"TEST_KINDS"`
+	Limits    map[string]int  `env:"TEST_LIMITS"`
+	Addr      netip.Addr      `env:"TEST_ADDR"`
+	Mongo     testMongoConfig `env:"TEST_MONGO_"`
+	Preset    string          `env:"TEST_PRESET"`
+	Pin       int             `env:"TEST_PIN,secret"`
+	Untagged  string
+	unexposed string
+}
+
+type testMongoConfig struct {
+	Database string        `env:"DATABASE" default:"nostr"`
+	Timeout  time.Duration `env:"TIMEOUT"`
+}
+
+func TestLoad(t *testing.T) {
+	cases := map[string]struct {
+		env        map[string]string
+		want       testConfig
+		wantErr    []string
+		notWantErr []string
+	}{
+		"defaults": {
+			env: map[string]string{"TEST_SECRET": "test/nostr/mongo/rw"},
+			want: testConfig{
+				Secret:  "test/nostr/mongo/rw",
+				Timeout: 5 * time.Second,
+				Mongo:   testMongoConfig{Database: "nostr"},
+				Preset:  "preset",
+			},
+		},
+		"all types": {
+			env: map[string]string{
+				"TEST_SECRET":        "test/nostr/mongo/rw",
+				"TEST_TIMEOUT":       "90",
+				"TEST_RATIO":         "0.25",
+				"TEST_SIZE":          "8589934592",
+				"TEST_ENABLED":       "true",
+				"TEST_PUBKEYS":       "aa, bb",
+				"TEST_KINDS":         "0,1,3",
+				"TEST_LIMITS":        "req:10, event:20",
+				"TEST_ADDR":          "192.0.2.1",
+				"TEST_MONGO_TIMEOUT": "2s",
+				"TEST_PRESET":        "changed",
+			},
+			want: testConfig{
+				Secret:  "test/nostr/mongo/rw",
+				Timeout: 90 * time.Second,
+				Ratio:   0.25,
+				Size:    8589934592,
+				Enabled: true,
+				Pubkeys: []string{"aa", "bb"},
+				Kinds:   []int{0, 1, 3},
+				Limits:  map[string]int{"req": 10, "event": 20},
+				Addr:    netip.MustParseAddr("192.0.2.1"),
+				Mongo:   testMongoConfig{Database: "nostr", Timeout: 2 * time.Second},
+				Preset:  "changed",
+			},
+		},
+		"all errors at once": {
+			env: map[string]string{
+				"TEST_TIMEOUT": "soon",
+				"TEST_KINDS":   "1,two",
+				"TEST_ADDR":    "localhost",
+			},
+			wantErr: []string{
+				"missing env variable: TEST_SECRET",
+				"failed to parse TEST_TIMEOUT=soon",
+				"failed to parse TEST_KINDS=1,two",
+				"failed to parse TEST_ADDR=localhost",
+			},
+		},
+		"secret values are redacted": {
+			env: map[string]string{
+				"TEST_SECRET": "test/nostr/mongo/rw",
+				"TEST_PIN":    "12a4",
+			},
+			wantErr:    []string{"failed to parse TEST_PIN=" + redacted},
+			notWantErr: []string{"12a4"},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range testCase.env {
+				t.Setenv(key, value)
+			}
+			cfg := testConfig{Preset: "preset"}
+			err := Load(&cfg)
+			if len(testCase.wantErr) > 0 {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				for _, want := range testCase.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("expected %q in error %v", want, err)
+					}
+				}
+				for _, notWant := range testCase.notWantErr {
+					if strings.Contains(err.Error(), notWant) {
+						t.Errorf("did not expect %q in error %v", notWant, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "config", cfg, testCase.want)
+		})
+	}
+}
+
+func TestLoadNotAStruct(t *testing.T) {
+	var cfg string
+	if err := Load(&cfg); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,200 @@
+package env
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The layers that values are loaded from, next to the dotenv files which are named by their path
+const (
+	LayerUnset       = "unset"
+	LayerPreset      = "preset"
+	LayerDefault     = "default"
+	LayerEnvironment = "environment"
+	LayerOverride    = "override"
+)
+
+// redacted replaces the values of secrets in a dump
+const redacted = "********"
+
+// Option adds sources to Load
+type Option func(l *loader) error
+
+// layer is a source of values, the later layers of a loader take precedence
+type layer struct {
+	name   string
+	values map[string]string
+}
+
+type loader struct {
+	// layers are the layers before and after the environment
+	before, after []layer
+	report        *Report
+}
+
+// WithDotenv loads values from the dotenv files at the paths, which take
+// precedence over the defaults, and in turn over each other in order.
+// The environment takes precedence over them. Missing files are skipped.
+func WithDotenv(paths ...string) Option {
+	return func(l *loader) error {
+		for _, path := range paths {
+			values, err := readDotenv(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			l.before = append(l.before, layer{name: path, values: values})
+		}
+		return nil
+	}
+}
+
+// WithLocalDotenv loads values from the .env file, and from the .env.{APP_ENV}
+// file for the environment named by APP_ENV, to run functions outside Lambda
+func WithLocalDotenv() Option {
+	paths := []string{".env"}
+	if environment := os.Getenv("APP_ENV"); environment != "" {
+		paths = append(paths, ".env."+environment)
+	}
+	return WithDotenv(paths...)
+}
+
+// WithOverrides loads the values, which take precedence over all other sources
+func WithOverrides(values map[string]string) Option {
+	return func(l *loader) error {
+		l.after = append(l.after, layer{name: LayerOverride, values: values})
+		return nil
+	}
+}
+
+// WithReport records the value of each variable and the layer it was loaded from in report
+func WithReport(report *Report) Option {
+	return func(l *loader) error {
+		l.report = report
+		return nil
+	}
+}
+
+// lookup returns the value of the variable in the layer with the highest precedence
+func (l *loader) lookup(name string) (string, string) {
+	for i := len(l.after) - 1; i >= 0; i-- {
+		if value := l.after[i].values[name]; value != "" {
+			return value, l.after[i].name
+		}
+	}
+	if value := os.Getenv(name); value != "" {
+		return value, LayerEnvironment
+	}
+	for i := len(l.before) - 1; i >= 0; i-- {
+		if value := l.before[i].values[name]; value != "" {
+			return value, l.before[i].name
+		}
+	}
+	return "", LayerUnset
+}
+
+func (l *loader) record(name, value, layer string, secret bool) {
+	if l.report != nil {
+		*l.report = append(*l.report, Resolved{Name: name, Value: value, Layer: layer, Secret: secret})
+	}
+}
+
+// Resolved is the value of a variable, and the layer it was loaded from
+type Resolved struct {
+	Name   string
+	Value  string
+	Layer  string
+	Secret bool
+}
+
+// Report is the resolved variables of a config, in the order of its fields
+type Report []Resolved
+
+// Dump writes the variables with their layers, redacting the values of secrets
+func (r Report) Dump(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, resolved := range r {
+		value := resolved.Value
+		if resolved.Secret && value != "" {
+			value = redacted
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", resolved.Name, value, resolved.Layer)
+	}
+	return tw.Flush()
+}
+
+// isSecret tells if the value of a variable must not be shown, by the secret
+// option of its tag or by its name. DB_SECRET is not one, as it names the secret
+// in Secrets Manager rather than holding it.
+func isSecret(name string, options []string) bool {
+	if slices.Contains(options, "secret") {
+		return true
+	}
+	for _, word := range []string{"PASSWORD", "TOKEN", "PRIVATE_KEY", "API_KEY", "SECRET_KEY", "SECRET_ACCESS_KEY"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// readDotenv reads the KEY=value lines of a dotenv file. Values may be quoted,
+// with escapes in double quotes, and lines may start with export.
+func readDotenv(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNumber)
+		}
+		value, err := dotenvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+func dotenvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", errors.New("unterminated double quote")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return value[1:end], nil
+	}
+	// unquoted values end at a comment
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,103 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	dotenv := writeFile(t, dir, ".env", "LAYER_DB=local\nLAYER_LIMIT=10\nLAYER_NAME=dotenv\n")
+	environmentDotenv := writeFile(t, dir, ".env.test", "LAYER_LIMIT=20\nLAYER_NAME=test\n")
+	t.Setenv("LAYER_NAME", "environment")
+
+	var cfg struct {
+		DB       string `env:"LAYER_DB"`
+		Limit    int    `env:"LAYER_LIMIT" default:"5"`
+		Name     string `env:"LAYER_NAME"`
+		Override string `env:"LAYER_OVERRIDE" default:"default"`
+		Timeout  string `env:"LAYER_TIMEOUT" default:"1s"`
+		Token    string `env:"LAYER_TOKEN"`
+		Missing  string `env:"LAYER_MISSING"`
+	}
+	var report Report
+	err := Load(&cfg,
+		WithDotenv(dotenv, environmentDotenv, filepath.Join(dir, ".env.missing")),
+		WithOverrides(map[string]string{"LAYER_OVERRIDE": "override", "LAYER_TOKEN": "s3cr3t"}),
+		WithReport(&report),
+	)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "report", report, Report{
+		{Name: "LAYER_DB", Value: "local", Layer: dotenv},
+		{Name: "LAYER_LIMIT", Value: "20", Layer: environmentDotenv},
+		{Name: "LAYER_NAME", Value: "environment", Layer: LayerEnvironment},
+		{Name: "LAYER_OVERRIDE", Value: "override", Layer: LayerOverride},
+		{Name: "LAYER_TIMEOUT", Value: "1s", Layer: LayerDefault},
+		{Name: "LAYER_TOKEN", Value: "s3cr3t", Layer: LayerOverride, Secret: true},
+		{Name: "LAYER_MISSING", Layer: LayerUnset},
+	})
+	verify.Values(t, "limit", cfg.Limit, 20)
+
+	var dump strings.Builder
+	if err := report.Dump(&dump); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	if strings.Contains(dump.String(), "s3cr3t") {
+		t.Errorf("expected the token to be redacted in\n%s", dump.String())
+	}
+	if !strings.Contains(dump.String(), "LAYER_TOKEN     "+redacted+"     (override)") {
+		t.Errorf("expected the redacted token in\n%s", dump.String())
+	}
+}
+
+func TestReadDotenv(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		"values": {
+			content: "# comment\n\nA=1\nexport B=two words\nC=\"quoted # not a comment\\n\"\nD='single'\nE=value # comment\n",
+			want: map[string]string{
+				"A": "1",
+				"B": "two words",
+				"C": "quoted # not a comment\n",
+				"D": "single",
+				"E": "value",
+			},
+		},
+		"no assignment": {
+			content: "A\n",
+			wantErr: true,
+		},
+		"unterminated quote": {
+			content: "A=\"open\n",
+			wantErr: true,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := readDotenv(writeFile(t, t.TempDir(), ".env", testCase.content))
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			if !testCase.wantErr {
+				verify.Values(t, "values", got, testCase.want)
+			}
+		})
+	}
+}
+
+/// Helper Functions ///
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
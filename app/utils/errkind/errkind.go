@@ -0,0 +1,49 @@
+/*
+Package errkind classifies errors by how clients should handle them, so that
+handlers map them to HTTP statuses and NIP-01 prefixes the same way everywhere
+*/
+package errkind
+
+import (
+	"errors"
+)
+
+// Kind is the class of an error
+type Kind string
+
+// The kinds of errors. Errors that are not classified are Internal.
+const (
+	Internal     Kind = "internal"
+	Validation   Kind = "validation"
+	NotFound     Kind = "not found"
+	Conflict     Kind = "conflict"
+	RateLimited  Kind = "rate limited"
+	Unauthorized Kind = "unauthorized"
+	Forbidden    Kind = "forbidden"
+	Unavailable  Kind = "unavailable"
+)
+
+// Error is an error of a kind, with a message that may be shown to clients.
+// Add details by wrapping it, as in fmt.Errorf("%w: details", err).
+type Error struct {
+	Kind    Kind
+	Message string
+}
+
+// New returns an error of the kind
+func New(kind Kind, message string) error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Of returns the kind of the first Error that err wraps, or Internal when there is none
+func Of(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Internal
+}
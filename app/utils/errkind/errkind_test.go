@@ -0,0 +1,39 @@
+package errkind
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestOf(t *testing.T) {
+	errMissing := New(NotFound, "not found")
+	cases := map[string]struct {
+		err  error
+		want Kind
+	}{
+		"classified": {
+			err:  errMissing,
+			want: NotFound,
+		},
+		"wrapped": {
+			err:  fmt.Errorf("%w: event %s", errMissing, "e"),
+			want: NotFound,
+		},
+		"joined": {
+			err:  errors.Join(errors.New("other"), New(RateLimited, "slow down")),
+			want: RateLimited,
+		},
+		"not classified": {
+			err:  errors.New("connection refused"),
+			want: Internal,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			verify.Values(t, "kind", Of(testCase.err), testCase.want)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Package nostr checks events as NIP-01 describes them, for the relay and for the
+NIP-98 authorization of its HTTP APIs
+*/
+package nostr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+var (
+	ErrInvalidID        = errkind.New(errkind.Validation, "invalid: event id does not match")
+	ErrInvalidSignature = errkind.New(errkind.Validation, "invalid: signature verification failed")
+)
+
+// Event holds the fields of a nostr event. Types with the same fields convert to it,
+// as in nostr.Event(e).Verify().
+type Event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Serialize returns the canonical serialization used to compute the event id.
+// Strings are written as received, only escaping what NIP-01 escapes.
+func (e Event) Serialize() ([]byte, error) {
+	b := make([]byte, 0, 128+len(e.Content))
+	b = append(b, "[0,"...)
+	b = appendString(b, e.PubKey)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, e.CreatedAt, 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(e.Kind), 10)
+	b = append(b, ",["...)
+	for i, tag := range e.Tags {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, '[')
+		for j, value := range tag {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, value)
+		}
+		b = append(b, ']')
+	}
+	b = append(b, "],"...)
+	b = appendString(b, e.Content)
+	return append(b, ']'), nil
+}
+
+// appendString appends s as JSON string, escaping line breaks, quotes, backslashes and
+// other control characters, and leaving all other characters and bytes verbatim
+func appendString(b []byte, s string) []byte {
+	const hexDigits = "0123456789abcdef"
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\n':
+			b = append(b, `\n`...)
+		case '"':
+			b = append(b, `\"`...)
+		case '\\':
+			b = append(b, `\\`...)
+		case '\r':
+			b = append(b, `\r`...)
+		case '\t':
+			b = append(b, `\t`...)
+		case '\b':
+			b = append(b, `\b`...)
+		case '\f':
+			b = append(b, `\f`...)
+		default:
+			if c < 0x20 {
+				b = append(b, `\u00`...)
+				b = append(b, hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+// ComputeID returns the hex encoded sha256 of the serialized event
+func (e Event) ComputeID() (string, error) {
+	serialized, err := e.Serialize()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(serialized)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Verify checks that the id matches the content, and that the signature is valid for the pubkey
+func (e Event) Verify() error {
+	id, err := e.ComputeID()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidID, err)
+	}
+	if id != e.ID {
+		return ErrInvalidID
+	}
+	// hex is lowercase in NIP-01, and the id covers the pubkey as written
+	if !isLowerHex(e.PubKey) {
+		return fmt.Errorf("%w: bad pubkey: expected lowercase hex", ErrInvalidSignature)
+	}
+	pubKey, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return fmt.Errorf("%w: bad pubkey: %v", ErrInvalidSignature, err)
+	}
+	key, err := schnorr.ParsePubKey(pubKey)
+	if err != nil {
+		return fmt.Errorf("%w: bad pubkey: %v", ErrInvalidSignature, err)
+	}
+	if !isLowerHex(e.Sig) {
+		return fmt.Errorf("%w: bad signature: expected lowercase hex", ErrInvalidSignature)
+	}
+	sigBytes, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return fmt.Errorf("%w: bad signature: %v", ErrInvalidSignature, err)
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("%w: bad signature: %v", ErrInvalidSignature, err)
+	}
+	hash, _ := hex.DecodeString(id)
+	if !sig.Verify(hash, key) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
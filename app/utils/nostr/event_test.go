@@ -0,0 +1,95 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/pascaldekloe/goe/verify"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+func TestVerify(t *testing.T) {
+	key := mustNewKey(t)
+	cases := map[string]struct {
+		modify  func(e *Event)
+		wantErr error
+	}{
+		"valid":              {func(e *Event) {}, nil},
+		"line separators":    {func(e *Event) { *e = signed(t, key, Event{Content: "a\u2028b\u2029c"}) }, nil},
+		"uppercase pubkey":   {func(e *Event) { e.PubKey = strings.ToUpper(e.PubKey); e.ID, _ = e.ComputeID() }, ErrInvalidSignature},
+		"uppercase sig":      {func(e *Event) { e.Sig = strings.ToUpper(e.Sig) }, ErrInvalidSignature},
+		"uppercase id":       {func(e *Event) { e.ID = strings.ToUpper(e.ID) }, ErrInvalidID},
+		"changed content":    {func(e *Event) { e.Content = "changed" }, ErrInvalidID},
+		"changed tags":       {func(e *Event) { e.Tags = nil }, ErrInvalidID},
+		"changed sig":        {func(e *Event) { e.Sig = signed(t, key, Event{Content: "other"}).Sig }, ErrInvalidSignature},
+		"malformed sig":      {func(e *Event) { e.Sig = "zz" }, ErrInvalidSignature},
+		"malformed pubkey":   {func(e *Event) { e.PubKey = "zz"; e.ID, _ = e.ComputeID() }, ErrInvalidSignature},
+		"pubkey of other id": {func(e *Event) { e.PubKey = signed(t, mustNewKey(t), Event{}).PubKey; e.ID, _ = e.ComputeID() }, ErrInvalidSignature},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := signed(t, key, Event{CreatedAt: 1700000000, Kind: 1, Tags: [][]string{{"t", "nostr"}}, Content: "hello <world> & co"})
+			testCase.modify(&e)
+			err := e.Verify()
+			verify.Values(t, "error", errors.Is(err, testCase.wantErr), true)
+			if err != nil {
+				verify.Values(t, "kind", errkind.Of(err), errkind.Validation)
+			}
+		})
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	cases := map[string]struct {
+		event Event
+		want  string
+	}{
+		"html":             {Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Content: "a<b>&c"}, `[0,"abc",1,1,[],"a<b>&c"]`},
+		"escapes":          {Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Content: "\"\\\n\r\t\b\f"}, `[0,"abc",1,1,[],"\"\\\n\r\t\b\f"]`},
+		"control":          {Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Content: "\x00\x1f\x7f"}, "[0,\"abc\",1,1,[],\"\\u0000\\u001f\x7f\"]"},
+		"line separators":  {Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Content: "a\u2028b\u2029c"}, "[0,\"abc\",1,1,[],\"a\u2028b\u2029c\"]"},
+		"invalid utf-8":    {Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Content: "a\xffb"}, "[0,\"abc\",1,1,[],\"a\xffb\"]"},
+		"uppercase pubkey": {Event{PubKey: "ABC", CreatedAt: 1, Kind: 1}, `[0,"ABC",1,1,[],""]`},
+		"tags":             {Event{PubKey: "abc", CreatedAt: 1, Kind: 1, Tags: [][]string{{"e", "x\ny"}, {"t"}, {}}}, `[0,"abc",1,1,[["e","x\ny"],["t"],[]],""]`},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := testCase.event.Serialize()
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "serialized", string(got), testCase.want)
+		})
+	}
+}
+
+/// Helper Functions ///
+
+func mustNewKey(t *testing.T) *btcec.PrivateKey {
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	return key
+}
+
+func signed(t *testing.T, key *btcec.PrivateKey, e Event) Event {
+	e.PubKey = hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+	id, err := e.ComputeID()
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	e.ID = id
+	hash, _ := hex.DecodeString(id)
+	sig, err := schnorr.Sign(key, hash)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	e.Sig = hex.EncodeToString(sig.Serialize())
+	return e
+}
@@ -71,25 +71,81 @@ func (c UserPasswordConfig) toClientOptions() *options.ClientOptions {
 	return options.MergeClientOptions(opts, c.clientOptions)
 }
 
-// ConfigFromSecret creates the config from the secret
-func ConfigFromSecret(secretName string) (UserPasswordConfig, error) {
-	var c struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-		Database string `json:"database"`
-		Host     string `json:"host"`
+// secretConfig is the JSON in the secret. It either holds a username, password and
+// host for an SRV connection, a full connection string in uri, or the hosts and
+// options for a connection string. The latter also accepts the secrets generated
+// by DocumentDB, which have a host, port and ssl field.
+type secretConfig struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	Database string `json:"database" yaml:"database"`
+	Host     string `json:"host" yaml:"host"`
+
+	URI         string   `json:"uri" yaml:"uri"`
+	Hosts       []string `json:"hosts" yaml:"hosts"`
+	Port        int      `json:"port" yaml:"port"`
+	AuthSource  string   `json:"auth_source" yaml:"auth_source"`
+	ReplicaSet  string   `json:"replica_set" yaml:"replica_set"`
+	TLS         bool     `json:"tls" yaml:"tls"`
+	SSL         bool     `json:"ssl" yaml:"ssl"`
+	TLSCAFile   string   `json:"tls_ca_file" yaml:"tls_ca_file"`
+	RDSCABundle bool     `json:"rds_ca_bundle" yaml:"rds_ca_bundle"`
+	RetryWrites *bool    `json:"retry_writes" yaml:"retry_writes"`
+	Direct      bool     `json:"direct_connection" yaml:"direct_connection"`
+}
+
+func (c secretConfig) isURIConfig() bool {
+	return c.URI != "" || len(c.Hosts) > 0 || c.Port != 0 || c.TLS || c.SSL || c.RDSCABundle || c.ReplicaSet != ""
+}
+
+func (c secretConfig) toConfig() (Config, error) {
+	if !c.isURIConfig() {
+		return NewConfig(c.Username, c.Password, c.Database, c.Host)
 	}
-	err := secrets.NewService().GetAndUnmarshal(secretName, &c)
-	if err != nil {
-		return UserPasswordConfig{}, err
+	var cfg URIConfig
+	var err error
+	if c.URI != "" {
+		cfg, err = NewURIConfig(c.URI, c.Database)
+	} else {
+		hosts := c.Hosts
+		if c.Host != "" {
+			host := c.Host
+			if c.Port != 0 {
+				host = fmt.Sprintf("%s:%d", c.Host, c.Port)
+			}
+			hosts = append([]string{host}, hosts...)
+		}
+		cfg, err = NewHostsConfig(hosts, c.Username, c.Password, c.Database)
+		cfg.AuthSource = c.AuthSource
+		cfg.ReplicaSet = c.ReplicaSet
+		cfg.TLS = c.TLS || c.SSL
+		cfg.TLSCAFile = c.TLSCAFile
+		cfg.RetryWrites = c.RetryWrites
+		cfg.DirectConnection = c.Direct
 	}
-	cfg, err := NewConfig(c.Username, c.Password, c.Database, c.Host)
 	if err != nil {
-		return cfg, ErrInvalidConfig{secretName: secretName}
+		return cfg, err
+	}
+	if c.RDSCABundle {
+		return cfg.WithRDSCABundle()
 	}
 	return cfg, nil
 }
 
+// ConfigFromSecret creates the config from the secret
+func ConfigFromSecret(secretName string) (Config, error) {
+	return configFromSecret(secrets.NewService(), secretName)
+}
+
+func configFromSecret(svc secrets.Service, secretName string) (Config, error) {
+	var c secretConfig
+	if err := svc.GetAndUnmarshal(secretName, &c); err != nil {
+		return nil, err
+	}
+	cfg, err := c.toConfig()
+	return cfg, sourceErr(err, secretName)
+}
+
 // NewConfig creates a config based on the username and password
 func NewConfig(username string, password string, database string, host string) (UserPasswordConfig, error) {
 	cfg := UserPasswordConfig{
@@ -2,6 +2,7 @@ package skmongo
 
 import (
 	"context"
+	"errors"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -10,6 +11,14 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+const (
+	// authenticationFailedCode is the server error code for rejected credentials
+	authenticationFailedCode = 18
+	// changeStreamNotSupportedCode is the server error code for opening a change
+	// stream on a deployment that is not a replica set
+	changeStreamNotSupportedCode = 40573
+)
+
 type database struct {
 	name    string
 	client  *mongo.Client
@@ -39,6 +48,7 @@ func (db *database) verifyConnection(ctx context.Context) error {
 	}
 	if err := db.client.Ping(ctx, readpref.PrimaryPreferred()); err != nil {
 		log.WithError(err).Warning("problem occurs in client.Ping() in ping()")
+		_ = db.client.Disconnect(context.Background())
 		return err
 	}
 	return nil
@@ -56,8 +66,37 @@ func (db *database) initClient(ctx context.Context) error {
 
 // IsConnectionErr will validate if the given error is an error that occurred while connecting to MongoDB
 func IsConnectionErr(err error) bool {
+	if err == nil {
+		return false
+	}
 	return mongo.IsNetworkError(err) ||
 		mongo.IsTimeout(err) ||
 		strings.Contains(err.Error(), "error occured during connection") || // MongoDB driver misspells occurred
 		strings.Contains(err.Error(), "error occurred during connection")
 }
+
+// IsAuthErr will validate if the given error is an authentication failure, as happens
+// when the credentials in the secret were rotated
+func IsAuthErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(authenticationFailedCode) {
+		return true
+	}
+	return strings.Contains(err.Error(), "auth error") || strings.Contains(err.Error(), "Authentication failed")
+}
+
+// IsChangeStreamUnsupportedErr will validate if the given error is caused by
+// change streams not being available, as on a standalone server
+func IsChangeStreamUnsupportedErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamNotSupportedCode) {
+		return true
+	}
+	return strings.Contains(err.Error(), "only supported on replica sets")
+}
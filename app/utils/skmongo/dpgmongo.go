@@ -40,8 +40,26 @@ func NewWithContext(ctx context.Context, secretName string) (Mongo, error) {
 // Typically, used in the init of a Lambda function like this:
 //
 //	repo := NewRepository(dpgmongo.NewWithContextWithOptions(ctx, dbSecret, options.Client().SetMaxPoolSize(200))
+//
+// The secret name can also be any source supported by ProviderFor, such as env://MONGO_
+// or file://./local.json, to run without AWS credentials.
 func NewWithContextWithOptions(ctx context.Context, secretName string, clientOptions *options.ClientOptions) (Mongo, error) {
-	cfg, err := ConfigFromSecret(secretName)
+	provider, err := ProviderFor(secretName)
+	if err != nil {
+		return Mongo{}, err
+	}
+	return NewWithProvider(ctx, provider, clientOptions)
+}
+
+// NewWithProvider creates a new mongo that connects to the mongo DB as described
+// by the config from the provider.
+//
+// Typically, used to inject the secrets service:
+//
+//	provider, err := skmongo.ProviderFor(dbSecret, skmongo.WithSecretsService(secretsService))
+//	db, err := skmongo.NewWithProvider(ctx, provider, nil)
+func NewWithProvider(ctx context.Context, provider ConfigProvider, clientOptions *options.ClientOptions) (Mongo, error) {
+	cfg, err := provider.Config(ctx)
 	if err != nil {
 		return Mongo{}, fmt.Errorf("problem getting configuration from secret: %w", err)
 	}
@@ -2,17 +2,11 @@ package skmongo
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"io/fs"
-	"io/ioutil"
 	"os"
-	"path"
-	"strings"
 	"testing"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -105,36 +99,17 @@ func testCollectionName(collection string) string {
 	return fmt.Sprintf("test_%s_%d", collection, time.Now().UnixNano())
 }
 
-func findMigrationFiles() (path string, files []fs.FileInfo, err error) {
-	path = defaultMigrationPath
-	for i := 0; i < maxMigrationPathSearches; i++ {
-		files, err = ioutil.ReadDir(path)
-		if errors.Is(err, os.ErrNotExist) {
-			path = "../" + path
-			continue
-		}
-		return
-	}
-	return "", nil, nil
-}
-
 func applyMigrations(ctx context.Context, db *mongo.Database, srcCollection, testCollection string) error {
-	migrationFilesPath, files, err := findMigrationFiles()
+	migrationsDir := FindMigrationsDir()
+	if migrationsDir == "" {
+		return nil
+	}
+	migrations, err := LoadMigrations(migrationsDir)
 	if err != nil {
 		return err
 	}
-	for _, f := range files {
-		if !strings.HasSuffix(f.Name(), ".up.json") {
-			continue
-		}
-		dat, err := os.ReadFile(path.Join(migrationFilesPath, f.Name()))
-		if err != nil {
-			return err
-		}
-		var commands []bson.D
-		if err = bson.UnmarshalExtJSON(dat, true, &commands); err != nil {
-			return err
-		}
+	for _, migration := range migrations {
+		commands := migration.Up
 		for i := range commands {
 			for j := range commands[i] {
 				if commands[i][j].Key == "createIndexes" && commands[i][j].Value.(string) == srcCollection {
@@ -0,0 +1,288 @@
+package skmongo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection     = "schema_migrations"
+	migrationsLockCollection = "schema_migrations_lock"
+	migrationsLockID         = "lock"
+	defaultLockTTL           = 10 * time.Minute
+
+	upSuffix   = ".up.json"
+	downSuffix = ".down.json"
+)
+
+var (
+	// ErrMigrationLocked is returned when another process holds the migration lock
+	ErrMigrationLocked = errors.New("migrations are locked by another process")
+	// ErrChecksumMismatch is returned when an applied migration file was changed afterward
+	ErrChecksumMismatch = errors.New("applied migration has been modified")
+)
+
+// Migration is a versioned set of database commands, read from
+// <version>_<name>.up.json and the optional <version>_<name>.down.json
+type Migration struct {
+	Version  int
+	Name     string
+	Up       []bson.D
+	Down     []bson.D
+	Checksum string
+}
+
+// MigrationStatus tells if a migration has been applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	Checksum  string    `bson:"checksum"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// FindMigrationsDir searches the ops/migrations directory upward from the working directory.
+// It returns an empty path when there is none.
+func FindMigrationsDir() string {
+	dir := defaultMigrationPath
+	for i := 0; i < maxMigrationPathSearches; i++ {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		dir = "../" + dir
+	}
+	return ""
+}
+
+// LoadMigrations reads all migrations in the directory, ordered by version
+func LoadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), upSuffix) {
+			continue
+		}
+		base := strings.TrimSuffix(entry.Name(), upSuffix)
+		versionText, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s does not start with a version: %w", entry.Name(), err)
+		}
+		up, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := Migration{Version: version, Name: name}
+		if m.Up, err = parseCommands(up); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", entry.Name(), err)
+		}
+		hash := sha256.Sum256(up)
+		m.Checksum = hex.EncodeToString(hash[:])
+
+		down, err := os.ReadFile(path.Join(dir, base+downSuffix))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if m.Down, err = parseCommands(down); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", base+downSuffix, err)
+			}
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+func parseCommands(dat []byte) ([]bson.D, error) {
+	var commands []bson.D
+	if err := bson.UnmarshalExtJSON(dat, true, &commands); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// Migrator applies migrations to a database, recording them in the schema_migrations collection
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+	owner      string
+	lockTTL    time.Duration
+}
+
+// NewMigrator creates a migrator for the migrations on the database
+func NewMigrator(db Mongo, migrations []Migration, opts ...func(m *Migrator)) *Migrator {
+	host, _ := os.Hostname()
+	m := &Migrator{
+		db:         db.database,
+		migrations: migrations,
+		owner:      fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()),
+		lockTTL:    defaultLockTTL,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// WithLockTTL sets how long the lock is held before another process may take it over
+func WithLockTTL(ttl time.Duration) func(m *Migrator) {
+	return func(m *Migrator) {
+		m.lockTTL = ttl
+	}
+}
+
+// Status returns all known migrations, and when they were applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{Migration: migration}
+		if a, found := applied[migration.Version]; found {
+			statuses[i].AppliedAt = &a.AppliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies all pending migrations in order, and returns the applied ones
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if a, found := applied[migration.Version]; found {
+				if a.Checksum != migration.Checksum {
+					return fmt.Errorf("%w: version %d (%s)", ErrChecksumMismatch, migration.Version, migration.Name)
+				}
+				continue
+			}
+			log.WithField("version", migration.Version).WithField("name", migration.Name).Info("applying migration")
+			if err := m.run(ctx, migration.Up); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+			}
+			_, err := m.db.Collection(migrationsCollection).InsertOne(ctx, appliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				Checksum:  migration.Checksum,
+				AppliedAt: time.Now().UTC(),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the given number of most recently applied migrations, and returns the reverted ones
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, found := applied[migration.Version]; !found {
+				continue
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d (%s) has no down file", migration.Version, migration.Name)
+			}
+			log.WithField("version", migration.Version).WithField("name", migration.Name).Info("reverting migration")
+			if err := m.run(ctx, migration.Down); err != nil {
+				return fmt.Errorf("reverting migration %d (%s) failed: %w", migration.Version, migration.Name, err)
+			}
+			if _, err := m.db.Collection(migrationsCollection).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+				return fmt.Errorf("failed to remove migration record %d: %w", migration.Version, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) run(ctx context.Context, commands []bson.D) error {
+	for _, command := range commands {
+		if err := m.db.RunCommand(ctx, command).Err(); err != nil {
+			return fmt.Errorf("failed to execute command %v: %w", command, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	cursor, err := m.db.Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]appliedMigration, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// withLock runs fn while holding the migration lock. A lock that is not
+// released, for instance because the process was killed, expires after the lock TTL.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) error {
+	locks := m.db.Collection(migrationsLockCollection)
+	now := time.Now().UTC()
+	_, err := locks.UpdateOne(ctx,
+		bson.M{"_id": migrationsLockID, "expires_at": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": m.owner, "locked_at": now, "expires_at": now.Add(m.lockTTL)}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrMigrationLocked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := locks.DeleteOne(context.Background(), bson.M{"_id": migrationsLockID, "owner": m.owner}); err != nil {
+			log.WithError(err).Error("failed to release migration lock")
+		}
+	}()
+	return fn()
+}
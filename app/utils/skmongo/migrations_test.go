@@ -0,0 +1,80 @@
+package skmongo
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	cases := map[string]struct {
+		files        map[string]string
+		wantVersions []int
+		wantDown     []bool
+		wantErr      bool
+	}{
+		"ordered by version": {
+			files: map[string]string{
+				"0002_second.up.json":   `[{"createIndexes": "b", "indexes": []}]`,
+				"0001_first.up.json":    `[{"createIndexes": "a", "indexes": []}]`,
+				"0001_first.down.json":  `[{"dropIndexes": "a", "index": "x"}]`,
+				"README.md":             "ignored",
+				"0003_no_up.down.json":  `[]`,
+				"0010_tenth.up.json":    `[]`,
+				"0010_tenth.other.json": `ignored`,
+			},
+			wantVersions: []int{1, 2, 10},
+			wantDown:     []bool{true, false, false},
+		},
+		"duplicate version": {
+			files: map[string]string{
+				"0001_first.up.json": `[]`,
+				"1_again.up.json":    `[]`,
+			},
+			wantErr: true,
+		},
+		"no version": {
+			files:   map[string]string{"first.up.json": `[]`},
+			wantErr: true,
+		},
+		"invalid json": {
+			files:   map[string]string{"0001_first.up.json": `[{`},
+			wantErr: true,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			for file, content := range testCase.files {
+				if err := os.WriteFile(path.Join(dir, file), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := LoadMigrations(dir)
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			var versions []int
+			var down []bool
+			for _, m := range got {
+				versions = append(versions, m.Version)
+				down = append(down, m.Down != nil)
+				if m.Checksum == "" {
+					t.Errorf("missing checksum for %d", m.Version)
+				}
+			}
+			verify.Values(t, "versions", versions, testCase.wantVersions)
+			verify.Values(t, "down", down, testCase.wantDown)
+		})
+	}
+}
+
+func TestRepositoryMigrationsLoad(t *testing.T) {
+	dir := FindMigrationsDir()
+	if dir == "" {
+		t.Fatal("expected to find the ops/migrations directory")
+	}
+	if _, err := LoadMigrations(dir); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+}
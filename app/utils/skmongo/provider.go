@@ -0,0 +1,286 @@
+package skmongo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"gopkg.in/yaml.v3"
+
+	"github.com/superkruger/nostr_app_data/app/utils/aws/secrets"
+)
+
+// The schemes of the sources a config can be loaded from.
+// A source without a scheme is the name of a Secrets Manager secret.
+const (
+	SchemeSecretsManager = "secretsmanager://"
+	SchemeSSM            = "ssm://"
+	SchemeEnv            = "env://"
+	SchemeFile           = "file://"
+)
+
+// ConfigProvider loads the Mongo DB configuration
+type ConfigProvider interface {
+	Config(ctx context.Context) (Config, error)
+}
+
+type providerOptions struct {
+	secretsService secrets.Service
+	ssmClient      ssmiface.SSMAPI
+}
+
+// ProviderOption configures the providers created by ProviderFor
+type ProviderOption func(opts *providerOptions)
+
+// WithSecretsService sets the secrets service used to read Secrets Manager sources
+func WithSecretsService(svc secrets.Service) ProviderOption {
+	return func(opts *providerOptions) {
+		opts.secretsService = svc
+	}
+}
+
+// WithSSMClient sets the client used to read SSM Parameter Store sources
+func WithSSMClient(client ssmiface.SSMAPI) ProviderOption {
+	return func(opts *providerOptions) {
+		opts.ssmClient = client
+	}
+}
+
+// ProviderFor returns the provider for the source, such as
+//
+//	test/nostr/mongo/rw
+//	secretsmanager://test/nostr/mongo/rw
+//	ssm:///nostr/test/mongo
+//	env://MONGO_
+//	file://./local.json
+func ProviderFor(source string, opts ...ProviderOption) (ConfigProvider, error) {
+	var o providerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch {
+	case strings.HasPrefix(source, SchemeSSM):
+		return ssmProvider{name: strings.TrimPrefix(source, SchemeSSM), client: o.ssmClient}, nil
+	case strings.HasPrefix(source, SchemeEnv):
+		return envProvider{prefix: strings.TrimPrefix(source, SchemeEnv)}, nil
+	case strings.HasPrefix(source, SchemeFile):
+		return fileProvider{path: strings.TrimPrefix(source, SchemeFile)}, nil
+	case strings.Contains(source, "://") && !strings.HasPrefix(source, SchemeSecretsManager):
+		return nil, fmt.Errorf("unsupported mongo config source %q", source)
+	default:
+		return secretsManagerProvider{name: strings.TrimPrefix(source, SchemeSecretsManager), svc: o.secretsService}, nil
+	}
+}
+
+// ConfigFromSource loads the config from the source, see ProviderFor
+func ConfigFromSource(ctx context.Context, source string, opts ...ProviderOption) (Config, error) {
+	provider, err := ProviderFor(source, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Config(ctx)
+}
+
+// RefreshableProvider is a provider of which the config can change, like a rotated secret
+type RefreshableProvider interface {
+	ConfigProvider
+	// VersionedConfig returns the config, and the version it was read from
+	VersionedConfig(ctx context.Context) (Config, string, error)
+	// RefreshOnAuthFailure tells if the config changed from the version a client
+	// connected with when err is an authentication failure, in which case the
+	// client should be rebuilt with the new config.
+	RefreshOnAuthFailure(err error, version string) (bool, error)
+}
+
+var (
+	sharedSecretsOnce sync.Once
+	sharedSecrets     *secrets.CachingService
+)
+
+// SharedSecrets returns the process wide caching secrets service, used for
+// Secrets Manager sources when no secrets service is given
+func SharedSecrets() *secrets.CachingService {
+	sharedSecretsOnce.Do(func() {
+		sharedSecrets = secrets.NewCachingService(secrets.NewService())
+	})
+	return sharedSecrets
+}
+
+type secretsManagerProvider struct {
+	name string
+	svc  secrets.Service
+}
+
+func (p secretsManagerProvider) Config(_ context.Context) (Config, error) {
+	return configFromSecret(p.service(), p.name)
+}
+
+// VersionedConfig returns the config with the id of the secret version, or an
+// empty version when the secrets service does not tell versions apart
+func (p secretsManagerProvider) VersionedConfig(ctx context.Context) (Config, string, error) {
+	versioned, ok := p.service().(secrets.VersionedService)
+	if !ok {
+		cfg, err := p.Config(ctx)
+		return cfg, "", err
+	}
+	version, err := versioned.GetVersion(p.name, "")
+	if err != nil {
+		return nil, "", err
+	}
+	var c secretConfig
+	if err := json.Unmarshal([]byte(version.Value), &c); err != nil {
+		return nil, "", err
+	}
+	cfg, err := c.toConfig()
+	return cfg, version.ID, sourceErr(err, p.name)
+}
+
+func (p secretsManagerProvider) RefreshOnAuthFailure(err error, version string) (bool, error) {
+	if !IsAuthErr(err) {
+		return false, nil
+	}
+	refresher, ok := p.service().(interface {
+		RefreshOnAuthFailure(secretName string, versionID string) (bool, error)
+	})
+	if !ok {
+		// without a cache, the secret is read again on the next Config
+		return true, nil
+	}
+	return refresher.RefreshOnAuthFailure(p.name, version)
+}
+
+func (p secretsManagerProvider) service() secrets.Service {
+	if p.svc == nil {
+		return SharedSecrets()
+	}
+	return p.svc
+}
+
+type ssmProvider struct {
+	name   string
+	client ssmiface.SSMAPI
+}
+
+func (p ssmProvider) Config(ctx context.Context) (Config, error) {
+	client := p.client
+	if client == nil {
+		client = ssmClient()
+	}
+	out, err := client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(p.name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var c secretConfig
+	if err := json.Unmarshal([]byte(aws.StringValue(out.Parameter.Value)), &c); err != nil {
+		return nil, err
+	}
+	cfg, err := c.toConfig()
+	return cfg, sourceErr(err, SchemeSSM+p.name)
+}
+
+func ssmClient() ssmiface.SSMAPI {
+	var sess *session.Session
+	if _, ok := os.LookupEnv("AWS_REGION"); ok {
+		sess = session.Must(session.NewSession())
+	} else {
+		sess = session.Must(session.NewSession(&aws.Config{Region: aws.String("eu-west-1")}))
+	}
+	return ssm.New(sess)
+}
+
+// envProvider reads the config from environment variables with the prefix, such as
+// MONGO_URI, or MONGO_USERNAME, MONGO_PASSWORD, MONGO_DATABASE and MONGO_HOST.
+// The other fields follow the secret JSON in upper case, like MONGO_REPLICA_SET.
+type envProvider struct {
+	prefix string
+}
+
+func (p envProvider) Config(_ context.Context) (Config, error) {
+	get := func(name string) string {
+		return os.Getenv(p.prefix + name)
+	}
+	getBool := func(name string) (bool, error) {
+		if get(name) == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(get(name))
+	}
+	c := secretConfig{
+		URI:        get("URI"),
+		Username:   get("USERNAME"),
+		Password:   get("PASSWORD"),
+		Database:   get("DATABASE"),
+		Host:       get("HOST"),
+		AuthSource: get("AUTH_SOURCE"),
+		ReplicaSet: get("REPLICA_SET"),
+		TLSCAFile:  get("TLS_CA_FILE"),
+	}
+	var err error
+	if get("HOSTS") != "" {
+		c.Hosts = strings.Split(get("HOSTS"), ",")
+	}
+	if get("PORT") != "" {
+		if c.Port, err = strconv.Atoi(get("PORT")); err != nil {
+			return nil, fmt.Errorf("failed to parse %sPORT: %w", p.prefix, err)
+		}
+	}
+	for name, target := range map[string]*bool{"TLS": &c.TLS, "RDS_CA_BUNDLE": &c.RDSCABundle, "DIRECT_CONNECTION": &c.Direct} {
+		if *target, err = getBool(name); err != nil {
+			return nil, fmt.Errorf("failed to parse %s%s: %w", p.prefix, name, err)
+		}
+	}
+	if get("RETRY_WRITES") != "" {
+		retryWrites, err := getBool("RETRY_WRITES")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %sRETRY_WRITES: %w", p.prefix, err)
+		}
+		c.RetryWrites = &retryWrites
+	}
+	cfg, err := c.toConfig()
+	return cfg, sourceErr(err, SchemeEnv+p.prefix)
+}
+
+// fileProvider reads the config from a JSON or YAML file, with the same fields as the secret
+type fileProvider struct {
+	path string
+}
+
+func (p fileProvider) Config(_ context.Context) (Config, error) {
+	contents, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var c secretConfig
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &c)
+	default:
+		err = json.Unmarshal(contents, &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", p.path, err)
+	}
+	cfg, err := c.toConfig()
+	return cfg, sourceErr(err, SchemeFile+p.path)
+}
+
+// sourceErr names the source in ErrInvalidConfig errors
+func sourceErr(err error, source string) error {
+	if err == nil || errors.Is(err, ErrNoRDSCABundle) {
+		return err
+	}
+	return ErrInvalidConfig{secretName: source}
+}
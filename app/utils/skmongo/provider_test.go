@@ -0,0 +1,160 @@
+package skmongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/superkruger/nostr_app_data/app/utils/aws/secrets"
+)
+
+func TestProviderFor(t *testing.T) {
+	cases := map[string]struct {
+		source   string
+		wantType string
+		wantErr  bool
+	}{
+		"plain secret":    {"test/nostr/mongo/rw", "skmongo.secretsManagerProvider", false},
+		"secrets manager": {"secretsmanager://test/nostr/mongo/rw", "skmongo.secretsManagerProvider", false},
+		"ssm":             {"ssm:///nostr/test/mongo", "skmongo.ssmProvider", false},
+		"env":             {"env://MONGO_", "skmongo.envProvider", false},
+		"file":            {"file://./local.json", "skmongo.fileProvider", false},
+		"unknown":         {"vault://mongo", "", true},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := ProviderFor(testCase.source)
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			if err == nil {
+				verify.Values(t, "type", fmt.Sprintf("%T", got), testCase.wantType)
+			}
+		})
+	}
+}
+
+func TestSecretsManagerProvider(t *testing.T) {
+	svc := secrets.NewMockService(`{"uri":"mongodb://localhost:27017/nostr"}`)
+	cfg, err := ConfigFromSource(context.Background(), "secretsmanager://local", WithSecretsService(svc))
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "database", cfg.DatabaseName(), "nostr")
+}
+
+func TestSecretsManagerProviderRefreshOnAuthFailure(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"no error":     {nil, false},
+		"other error":  {errors.New("boom"), false},
+		"auth failure": {mongo.CommandError{Code: 18, Message: "Authentication failed."}, true},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			provider, _ := ProviderFor("local", WithSecretsService(secrets.NewMockService(`{}`)))
+			got, err := provider.(RefreshableProvider).RefreshOnAuthFailure(testCase.err, "mock")
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "refresh", got, testCase.want)
+		})
+	}
+}
+
+func TestSSMProvider(t *testing.T) {
+	client := &mockSSM{value: `{"uri":"mongodb://localhost:27017/nostr"}`}
+	cfg, err := ConfigFromSource(context.Background(), "ssm:///nostr/mongo", WithSSMClient(client))
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "database", cfg.DatabaseName(), "nostr")
+	verify.Values(t, "parameter", client.name, "/nostr/mongo")
+}
+
+func TestEnvProvider(t *testing.T) {
+	cases := map[string]struct {
+		env     map[string]string
+		wantURI string
+		wantErr bool
+	}{
+		"uri": {
+			env:     map[string]string{"MONGO_URI": "mongodb://localhost:27017/nostr"},
+			wantURI: "mongodb://localhost:27017/nostr",
+		},
+		"hosts": {
+			env: map[string]string{
+				"MONGO_HOST": "docdb", "MONGO_PORT": "27017", "MONGO_DATABASE": "db",
+				"MONGO_TLS": "true", "MONGO_REPLICA_SET": "rs0", "MONGO_RETRY_WRITES": "false",
+			},
+			wantURI: "mongodb://docdb:27017/?replicaSet=rs0&retryWrites=false&tls=true",
+		},
+		"invalid bool": {
+			env:     map[string]string{"MONGO_HOST": "docdb", "MONGO_DATABASE": "db", "MONGO_TLS": "maybe"},
+			wantErr: true,
+		},
+		"incomplete": {
+			env:     map[string]string{"MONGO_HOST": "docdb", "MONGO_TLS": "true"},
+			wantErr: true,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range testCase.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := ConfigFromSource(context.Background(), "env://MONGO_")
+			verify.Values(t, "error", err != nil, testCase.wantErr)
+			if err == nil {
+				verify.Values(t, "uri", cfg.(URIConfig).ConnectionString(), testCase.wantURI)
+			}
+		})
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	cases := map[string]struct {
+		file    string
+		content string
+		wantDB  string
+	}{
+		"json": {"local.json", `{"uri":"mongodb://localhost:27017","database":"nostr"}`, "nostr"},
+		"yaml": {"local.yaml", "uri: mongodb://localhost:27017\ndatabase: nostr_yaml\n", "nostr_yaml"},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			file := path.Join(t.TempDir(), testCase.file)
+			if err := os.WriteFile(file, []byte(testCase.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := ConfigFromSource(context.Background(), "file://"+file)
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			verify.Values(t, "database", cfg.DatabaseName(), testCase.wantDB)
+		})
+	}
+}
+
+/// Helper Types ///
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	value string
+	name  string
+}
+
+func (m *mockSSM) GetParameterWithContext(_ aws.Context, input *ssm.GetParameterInput, _ ...request.Option) (*ssm.GetParameterOutput, error) {
+	m.name = aws.StringValue(input.Name)
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(m.value)}}, nil
+}
@@ -0,0 +1,33 @@
+package skmongo
+
+import (
+	"crypto/x509"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+const rdsCABundleFile = "certs/global-bundle.pem"
+
+//go:embed certs
+var certs embed.FS
+
+// ErrNoRDSCABundle is returned when the binary was built without the RDS CA bundle
+var ErrNoRDSCABundle = errors.New("the RDS CA bundle was not embedded, run ops/fetch-rds-ca-bundle.sh before building")
+
+// RDSCertPool returns a cert pool with the embedded AWS RDS CA bundle
+func RDSCertPool() (*x509.CertPool, error) {
+	pem, err := certs.ReadFile(rdsCABundleFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoRDSCABundle
+	}
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", rdsCABundleFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,193 @@
+package skmongo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// defaultIdleCheck is how long a client can be idle before it is pinged on the next use
+const defaultIdleCheck = 5 * time.Minute
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*LazyMongo{}
+)
+
+// Shared returns the process wide lazily connected mongo for the secret, or any
+// other source supported by ProviderFor. It is reused across warm Lambda invocations.
+//
+// Typically, used in the init of a Lambda function like this:
+//
+//	cfg := config{}
+//	env.MustLoad(&cfg)
+//	repo := NewRepository(skmongo.Shared(cfg.DBSecret))
+//	lambda.StartWithOptions(h.handleRequest, lambda.WithEnableSIGTERM(skmongo.CloseAll))
+func Shared(secretName string) *LazyMongo {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if l, found := registry[secretName]; found {
+		return l
+	}
+	l := NewLazy(secretName, nil)
+	registry[secretName] = l
+	return l
+}
+
+// CloseAll disconnects all shared mongo clients
+func CloseAll() {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, l := range registry {
+		l.Close()
+	}
+}
+
+// LazyMongo connects to the database on first use instead of at init, so a
+// database problem during a cold start fails the invocation instead of the
+// container. A failed connection is retried on the next use, a client that was
+// idle for a while is pinged before use, and the client is rebuilt when its
+// credentials were rotated.
+type LazyMongo struct {
+	secretName    string
+	clientOptions *options.ClientOptions
+	idleCheck     time.Duration
+	now           func() time.Time
+
+	mu       sync.Mutex
+	provider ConfigProvider
+	// version is the version of the config that the client connected with
+	version  string
+	db       *mongo.Database
+	lastUsed time.Time
+}
+
+// NewLazy creates a lazily connected mongo for the secret, see Shared for the process wide one
+func NewLazy(secretName string, clientOptions *options.ClientOptions) *LazyMongo {
+	return &LazyMongo{
+		secretName:    secretName,
+		clientOptions: clientOptions,
+		idleCheck:     defaultIdleCheck,
+		now:           time.Now,
+	}
+}
+
+// CollectionFor returns the collection with the given name, connecting when needed
+func (l *LazyMongo) CollectionFor(ctx context.Context, name string) (*mongo.Collection, error) {
+	db, err := l.Database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.Collection(name), nil
+}
+
+// Database returns the database, connecting when needed
+func (l *LazyMongo) Database(ctx context.Context) (*mongo.Database, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.db != nil && now.Sub(l.lastUsed) > l.idleCheck {
+		if err := l.db.Client().Ping(ctx, readpref.PrimaryPreferred()); err != nil {
+			log.WithError(err).Warning("idle mongo client failed health check, reconnecting")
+			l.disconnect()
+		}
+	}
+	if l.db == nil {
+		if err := l.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	l.lastUsed = now
+	return l.db, nil
+}
+
+// Mongo returns the connected mongo, for code that needs a Mongo value
+func (l *LazyMongo) Mongo(ctx context.Context) (Mongo, error) {
+	db, err := l.Database(ctx)
+	if err != nil {
+		return Mongo{}, err
+	}
+	return Mongo{db}, nil
+}
+
+// Close disconnects the client, a next use connects again
+func (l *LazyMongo) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.disconnect()
+}
+
+// observe rebuilds the client on its next use when err shows that the
+// credentials were rejected and the secret has a new version
+func (l *LazyMongo) observe(err error) {
+	if !IsAuthErr(err) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	refreshable, ok := l.provider.(RefreshableProvider)
+	if !ok {
+		return
+	}
+	changed, refreshErr := refreshable.RefreshOnAuthFailure(err, l.version)
+	if refreshErr != nil {
+		log.WithError(refreshErr).Error("failed to refresh mongo config after authentication failure")
+		return
+	}
+	if changed {
+		log.Info("mongo credentials were rotated, reconnecting on next use")
+		l.disconnect()
+	}
+}
+
+func (l *LazyMongo) connect(ctx context.Context) error {
+	cfg, err := l.config(ctx)
+	if err != nil {
+		return fmt.Errorf("problem getting configuration from secret: %w", err)
+	}
+	db, err := databaseFor(ctx, cfg.DatabaseName(), cfg.WithClientOptions(l.clientOptions).toClientOptions())
+	if err != nil {
+		return fmt.Errorf("problem connecting to database: %w", err)
+	}
+	l.db = db
+	return nil
+}
+
+// config reads the config to connect with, and keeps its version to tell a rotation from other authentication failures
+func (l *LazyMongo) config(ctx context.Context) (Config, error) {
+	if l.provider == nil {
+		provider, err := ProviderFor(l.secretName)
+		if err != nil {
+			return nil, err
+		}
+		l.provider = provider
+	}
+	refreshable, ok := l.provider.(RefreshableProvider)
+	if !ok {
+		return l.provider.Config(ctx)
+	}
+	cfg, version, err := refreshable.VersionedConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.version = version
+	return cfg, nil
+}
+
+func (l *LazyMongo) disconnect() {
+	if l.db == nil {
+		return
+	}
+	log.Trace("closing mongo connection")
+	if err := l.db.Client().Disconnect(context.Background()); err != nil {
+		log.WithField("db", l.db.Name()).Errorf("error closing mongo connection: %v", err)
+	}
+	l.db = nil
+}
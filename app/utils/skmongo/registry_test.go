@@ -0,0 +1,78 @@
+package skmongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/superkruger/nostr_app_data/app/utils/aws/secrets"
+)
+
+func TestShared(t *testing.T) {
+	a := Shared("env://TEST_SHARED_A_")
+	verify.Values(t, "same source", Shared("env://TEST_SHARED_A_") == a, true)
+	verify.Values(t, "other source", Shared("env://TEST_SHARED_B_") == a, false)
+}
+
+func TestLazyConnectFailure(t *testing.T) {
+	provider := &failingProvider{err: errors.New("secret unavailable")}
+	l := NewLazy("unused", nil)
+	l.provider = provider
+
+	for i := 0; i < 2; i++ {
+		_, err := l.CollectionFor(context.Background(), "events")
+		verify.Values(t, "error", errors.Is(err, provider.err), true)
+	}
+	verify.Values(t, "connect attempts", provider.calls, 2)
+}
+
+func TestLazyRotationAfterCacheRefresh(t *testing.T) {
+	inner := &rotatingSecrets{versionID: "v1"}
+	svc := secrets.NewCachingService(inner)
+	var rotated []string
+	svc.OnRotation(func(secretName string) { rotated = append(rotated, secretName) })
+	authErr := mongo.CommandError{Code: 18, Message: "Authentication failed."}
+
+	l := NewLazy("secret", nil)
+	l.provider, _ = ProviderFor("secret", WithSecretsService(svc))
+	if _, err := l.config(context.Background()); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	l.observe(authErr)
+	verify.Values(t, "rotations without a new version", len(rotated), 0)
+
+	t.Log("when the secret is rotated, and another reader refreshes the cache before the credentials are rejected")
+	inner.versionID = "v2"
+	svc.Invalidate("secret")
+	other, _ := ProviderFor("secret", WithSecretsService(svc))
+	if _, err := other.Config(context.Background()); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	l.observe(authErr)
+	verify.Values(t, "rotations", rotated, []string{"secret"})
+}
+
+/// Helper Types ///
+
+type failingProvider struct {
+	err   error
+	calls int
+}
+
+func (p *failingProvider) Config(_ context.Context) (Config, error) {
+	p.calls++
+	return nil, p.err
+}
+
+// rotatingSecrets returns the current version of a secret, which can be rotated by changing versionID
+type rotatingSecrets struct {
+	secrets.VersionedService
+	versionID string
+}
+
+func (s *rotatingSecrets) GetVersion(_ string, _ string) (secrets.Version, error) {
+	return secrets.Version{ID: s.versionID, Value: `{"uri":"mongodb://localhost:27017/nostr"}`}, nil
+}
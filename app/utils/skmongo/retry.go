@@ -0,0 +1,117 @@
+package skmongo
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// transientErrorCodes are the server error codes for elections, shutdowns and
+// network problems between the cluster members, after which a retry can succeed
+var transientErrorCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// RetryPolicy configures the retries of transient errors
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubling for each next retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy for Lambda functions
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+}
+
+// IsTransientErr will validate if the given error is a network, timeout or
+// election error, after which an idempotent operation can be retried
+func IsTransientErr(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if IsConnectionErr(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+	for _, code := range transientErrorCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry calls fn until it succeeds, fails with an error that is not transient,
+// or the attempts of the policy are used up. It waits with jittered exponential
+// backoff between attempts, and stops early when the remaining time before the
+// deadline of the context, like the one of a Lambda invocation, is too short to wait.
+// Retries are recorded as annotations on the X-Ray segment in the context.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(ctx); !IsTransientErr(err) {
+			return err
+		}
+		if attempt+1 >= policy.MaxAttempts {
+			return err
+		}
+		delay := policy.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < 2*delay {
+			log.WithError(err).Warn("not retrying, too close to the deadline")
+			return err
+		}
+		_ = xray.AddAnnotation(ctx, "retries", attempt+1)
+		_ = xray.AddAnnotation(ctx, "retry_error", err.Error())
+		log.WithError(err).WithField("attempt", attempt+1).Warn("retrying after transient error")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns a random delay up to the exponential backoff for the attempt
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.BaseDelay << attempt
+	if backoff > p.MaxDelay || backoff <= 0 {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
@@ -0,0 +1,80 @@
+package skmongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsTransientErr(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want bool
+	}{
+		"nil":                {nil, false},
+		"canceled":           {context.Canceled, false},
+		"deadline":           {context.DeadlineExceeded, true},
+		"connection":         {errors.New("connection() error occurred during connection handshake"), true},
+		"stepped down":       {mongo.CommandError{Code: 189}, true},
+		"not primary":        {mongo.CommandError{Code: 10107}, true},
+		"retryable label":    {mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}, true},
+		"duplicate key":      {mongo.CommandError{Code: 11000}, false},
+		"auth":               {mongo.CommandError{Code: 18}, false},
+		"not found":          {mongo.ErrNoDocuments, false},
+		"other":              {errors.New("boom"), false},
+		"wrapped transient":  {errors.Join(errors.New("query"), mongo.CommandError{Code: 91}), true},
+		"write not primary":  {mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 10107}}}, true},
+		"write dup key":      {mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}, false},
+		"interrupted change": {mongo.CommandError{Code: 11602}, true},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			verify.Values(t, name, IsTransientErr(testCase.err), testCase.want)
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	transient := mongo.CommandError{Code: 189}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	cases := map[string]struct {
+		errs         []error
+		timeout      time.Duration
+		wantErr      error
+		wantAttempts int
+	}{
+		"success":            {[]error{nil}, time.Second, nil, 1},
+		"recovers":           {[]error{transient, transient, nil}, time.Second, nil, 3},
+		"attempts used up":   {[]error{transient, transient, transient, nil}, time.Second, transient, 3},
+		"permanent":          {[]error{errors.New("boom"), nil}, time.Second, errors.New("boom"), 1},
+		"too close deadline": {[]error{transient, nil}, time.Nanosecond, transient, 1},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testCase.timeout)
+			defer cancel()
+			attempts := 0
+			err := Retry(ctx, policy, func(ctx context.Context) error {
+				err := testCase.errs[attempts]
+				attempts++
+				return err
+			})
+			verify.Values(t, "error", err, testCase.wantErr)
+			verify.Values(t, "attempts", attempts, testCase.wantAttempts)
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for attempt := 0; attempt < 100; attempt++ {
+		delay := policy.delay(attempt)
+		if delay <= 0 || delay > policy.MaxDelay {
+			t.Fatalf("delay %v for attempt %d out of range", delay, attempt)
+		}
+	}
+}
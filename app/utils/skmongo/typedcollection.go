@@ -0,0 +1,352 @@
+package skmongo
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/superkruger/nostr_app_data/app/utils/errkind"
+)
+
+// ErrNotFound is returned when no document matches the filter
+var ErrNotFound = errkind.New(errkind.NotFound, "not found")
+
+// ErrInvalidPageToken is returned when the page token can not be decoded
+var ErrInvalidPageToken = errkind.New(errkind.Validation, "invalid page token")
+
+// CollectionProvider provides the collections to operate on
+type CollectionProvider interface {
+	CollectionFor(ctx context.Context, name string) (*mongo.Collection, error)
+}
+
+// CollectionFor returns the collection with the given name, to implement CollectionProvider
+func (m Mongo) CollectionFor(_ context.Context, name string) (*mongo.Collection, error) {
+	return m.database.Collection(name), nil
+}
+
+// TypedCollection is a collection of documents of type T. All operations are
+// captured in X-Ray subsegments named "DB - <collection> <operation>", and the
+// idempotent reads and upserts are retried on transient errors.
+type TypedCollection[T any] struct {
+	db     CollectionProvider
+	name   string
+	policy RetryPolicy
+}
+
+// NewTypedCollection creates the typed collection with the default retry policy
+func NewTypedCollection[T any](db CollectionProvider, name string) TypedCollection[T] {
+	return TypedCollection[T]{db: db, name: name, policy: DefaultRetryPolicy()}
+}
+
+// WithRetryPolicy returns the collection using the retry policy
+func (c TypedCollection[T]) WithRetryPolicy(policy RetryPolicy) TypedCollection[T] {
+	c.policy = policy
+	return c
+}
+
+// Name returns the name of the collection
+func (c TypedCollection[T]) Name() string {
+	return c.name
+}
+
+// FindOne returns the first document matching the filter, or ErrNotFound
+func (c TypedCollection[T]) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) (T, error) {
+	var result T
+	err := c.capture(ctx, "find one", true, func(ctx context.Context, coll *mongo.Collection) error {
+		err := coll.FindOne(ctx, filter, opts...).Decode(&result)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNotFound
+		}
+		return err
+	})
+	return result, err
+}
+
+// Find calls fn for each document matching the filter, stopping at the first error
+func (c TypedCollection[T]) Find(ctx context.Context, filter interface{}, fn func(T) error, opts ...*options.FindOptions) error {
+	return c.capture(ctx, "find", false, func(ctx context.Context, coll *mongo.Collection) error {
+		var cursor *mongo.Cursor
+		// only opening the cursor is retried, as documents passed to fn can not be taken back
+		err := Retry(ctx, c.policy, func(ctx context.Context) error {
+			var err error
+			cursor, err = coll.Find(ctx, filter, opts...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		for cursor.Next(ctx) {
+			var doc T
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	})
+}
+
+// FindAll returns all documents matching the filter
+func (c TypedCollection[T]) FindAll(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	var results []T
+	err := c.capture(ctx, "find all", true, func(ctx context.Context, coll *mongo.Collection) error {
+		cursor, err := coll.Find(ctx, filter, opts...)
+		if err != nil {
+			return err
+		}
+		results = nil
+		return cursor.All(ctx, &results)
+	})
+	return results, err
+}
+
+// InsertOne inserts the document
+func (c TypedCollection[T]) InsertOne(ctx context.Context, doc T) error {
+	return c.capture(ctx, "insert one", false, func(ctx context.Context, coll *mongo.Collection) error {
+		_, err := coll.InsertOne(ctx, doc)
+		return err
+	})
+}
+
+// Upsert replaces the document matching the filter, or inserts it when there is none
+func (c TypedCollection[T]) Upsert(ctx context.Context, filter interface{}, doc T) error {
+	return c.capture(ctx, "upsert", true, func(ctx context.Context, coll *mongo.Collection) error {
+		_, err := coll.ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+// UpdateOne applies the update to the first document matching the filter, or returns ErrNotFound
+func (c TypedCollection[T]) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) error {
+	return c.capture(ctx, "update one", false, func(ctx context.Context, coll *mongo.Collection) error {
+		result, err := coll.UpdateOne(ctx, filter, update, opts...)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteMany deletes the documents matching the filter, and returns how many were deleted
+func (c TypedCollection[T]) DeleteMany(ctx context.Context, filter interface{}) (int64, error) {
+	var deleted int64
+	err := c.capture(ctx, "delete many", false, func(ctx context.Context, coll *mongo.Collection) error {
+		result, err := coll.DeleteMany(ctx, filter)
+		if err != nil {
+			return err
+		}
+		deleted = result.DeletedCount
+		return nil
+	})
+	return deleted, err
+}
+
+// Count counts the documents matching the filter
+func (c TypedCollection[T]) Count(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	var count int64
+	err := c.capture(ctx, "count", true, func(ctx context.Context, coll *mongo.Collection) error {
+		var err error
+		count, err = coll.CountDocuments(ctx, filter, opts...)
+		return err
+	})
+	return count, err
+}
+
+// BulkWrite executes the write models
+func (c TypedCollection[T]) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	var result *mongo.BulkWriteResult
+	err := c.capture(ctx, "bulk write", false, func(ctx context.Context, coll *mongo.Collection) error {
+		var err error
+		result, err = coll.BulkWrite(ctx, models, opts...)
+		return err
+	})
+	return result, err
+}
+
+// changeEvent is the part of a change stream event that Watch uses
+type changeEvent[T any] struct {
+	FullDocument T `bson:"fullDocument"`
+}
+
+// Watch calls fn with the full document of each insert and replace in the
+// collection, together with the resume token to pass as resumeAfter when the
+// stream has to be opened again. It runs until ctx is done or fn fails, and is
+// not captured in X-Ray as it outlives any single request.
+func (c TypedCollection[T]) Watch(ctx context.Context, resumeAfter bson.Raw, fn func(doc T, resumeToken bson.Raw) error) error {
+	coll, err := c.db.CollectionFor(ctx, c.name)
+	if err != nil {
+		return err
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "replace"}}}}},
+	}
+	opts := options.ChangeStream()
+	if resumeAfter != nil {
+		opts.SetResumeAfter(resumeAfter)
+	}
+	stream, err := coll.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	for stream.Next(ctx) {
+		var event changeEvent[T]
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(event.FullDocument, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// PageRequest requests a page of documents, sorted on a field with the _id as tie-breaker
+type PageRequest struct {
+	SortField  string
+	Descending bool
+	Limit      int
+	// Token is the Next token of the previous page, empty for the first page
+	Token string
+}
+
+// Page is a page of documents
+type Page[T any] struct {
+	Items []T
+	// Next is the token for the next page, empty when this is the last page
+	Next string
+}
+
+// Page returns a page of the documents matching the filter, using keyset
+// pagination so later pages are as cheap as the first one
+func (c TypedCollection[T]) Page(ctx context.Context, filter bson.M, request PageRequest) (Page[T], error) {
+	var page Page[T]
+	query, err := pageQuery(filter, request)
+	if err != nil {
+		return page, err
+	}
+	direction := 1
+	if request.Descending {
+		direction = -1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: request.SortField, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(request.Limit) + 1)
+	err = c.capture(ctx, "page", true, func(ctx context.Context, coll *mongo.Collection) error {
+		cursor, err := coll.Find(ctx, query, opts)
+		if err != nil {
+			return err
+		}
+		var raws []bson.Raw
+		if err := cursor.All(ctx, &raws); err != nil {
+			return err
+		}
+		page = Page[T]{}
+		for i, raw := range raws {
+			if i == request.Limit {
+				page.Next, err = encodePageToken(raws[i-1], request.SortField)
+				return err
+			}
+			var doc T
+			if err := bson.Unmarshal(raw, &doc); err != nil {
+				return err
+			}
+			page.Items = append(page.Items, doc)
+		}
+		return nil
+	})
+	return page, err
+}
+
+type pageToken struct {
+	Value interface{} `bson:"v"`
+	ID    interface{} `bson:"id"`
+}
+
+func encodePageToken(last bson.Raw, sortField string) (string, error) {
+	token := pageToken{Value: last.Lookup(sortField), ID: last.Lookup("_id")}
+	b, err := bson.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodePageToken(s string) (pageToken, error) {
+	var token pageToken
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return token, ErrInvalidPageToken
+	}
+	if err := bson.Unmarshal(b, &token); err != nil {
+		return token, ErrInvalidPageToken
+	}
+	return token, nil
+}
+
+// pageQuery adds the keyset condition for the page after the token to the filter
+func pageQuery(filter bson.M, request PageRequest) (bson.M, error) {
+	if request.SortField == "" || request.Limit <= 0 {
+		return nil, fmt.Errorf("a page needs a sort field and a positive limit")
+	}
+	if request.Token == "" {
+		return filter, nil
+	}
+	token, err := decodePageToken(request.Token)
+	if err != nil {
+		return nil, err
+	}
+	op := "$gt"
+	if request.Descending {
+		op = "$lt"
+	}
+	after := bson.M{"$or": bson.A{
+		bson.M{request.SortField: bson.M{op: token.Value}},
+		bson.M{request.SortField: token.Value, "_id": bson.M{op: token.ID}},
+	}}
+	if len(filter) == 0 {
+		return after, nil
+	}
+	return bson.M{"$and": bson.A{filter, after}}, nil
+}
+
+func (c TypedCollection[T]) capture(ctx context.Context, operation string, retry bool, fn func(ctx context.Context, coll *mongo.Collection) error) error {
+	return xray.Capture(ctx, "DB - "+c.name+" "+operation, func(ctx1 context.Context) error {
+		coll, err := c.db.CollectionFor(ctx1, c.name)
+		if err != nil {
+			return err
+		}
+		if !retry {
+			err = fn(ctx1, coll)
+		} else {
+			err = Retry(ctx1, c.policy, func(ctx context.Context) error {
+				return fn(ctx, coll)
+			})
+		}
+		if observer, ok := c.db.(errorObserver); ok && err != nil {
+			observer.observe(err)
+		}
+		return err
+	})
+}
+
+// errorObserver is implemented by collection providers that act on errors, such
+// as reconnecting after an authentication failure
+type errorObserver interface {
+	observe(err error)
+}
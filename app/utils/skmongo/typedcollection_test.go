@@ -0,0 +1,70 @@
+package skmongo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPageToken(t *testing.T) {
+	last, err := bson.Marshal(bson.M{"_id": "abc", "created_at": int64(1700000000), "other": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := encodePageToken(last, "created_at")
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	got, err := decodePageToken(token)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	verify.Values(t, "value", got.Value, int64(1700000000))
+	verify.Values(t, "id", got.ID, "abc")
+}
+
+func TestPageQuery(t *testing.T) {
+	last, _ := bson.Marshal(bson.M{"_id": "abc", "created_at": int64(10)})
+	token, _ := encodePageToken(last, "created_at")
+	after := func(op string) bson.M {
+		return bson.M{"$or": bson.A{
+			bson.M{"created_at": bson.M{op: int64(10)}},
+			bson.M{"created_at": int64(10), "_id": bson.M{op: "abc"}},
+		}}
+	}
+	cases := map[string]struct {
+		filter  bson.M
+		request PageRequest
+		want    bson.M
+		wantErr error
+	}{
+		"first page": {
+			filter:  bson.M{"kind": 1},
+			request: PageRequest{SortField: "created_at", Limit: 10},
+			want:    bson.M{"kind": 1},
+		},
+		"next page ascending": {
+			filter:  bson.M{},
+			request: PageRequest{SortField: "created_at", Limit: 10, Token: token},
+			want:    after("$gt"),
+		},
+		"next page descending with filter": {
+			filter:  bson.M{"kind": 1},
+			request: PageRequest{SortField: "created_at", Descending: true, Limit: 10, Token: token},
+			want:    bson.M{"$and": bson.A{bson.M{"kind": 1}, after("$lt")}},
+		},
+		"invalid token": {
+			request: PageRequest{SortField: "created_at", Limit: 10, Token: "!!"},
+			wantErr: ErrInvalidPageToken,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := pageQuery(testCase.filter, testCase.request)
+			verify.Values(t, "error", errors.Is(err, testCase.wantErr), true)
+			verify.Values(t, "query", got, testCase.want)
+		})
+	}
+}
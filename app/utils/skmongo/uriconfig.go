@@ -0,0 +1,159 @@
+package skmongo
+
+import (
+	"crypto/tls"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// URIConfig is the Mongo DB configuration using a connection string, either given
+// as a whole or built from its parts. Use it for DocumentDB, local development
+// and custom authentication sources.
+type URIConfig struct {
+	// URI is the full connection string. When set, the structured fields below are ignored.
+	URI      string
+	Database string
+
+	Hosts      []string
+	username   string
+	password   string
+	AuthSource string
+	ReplicaSet string
+	TLS        bool
+	// TLSCAFile is the path to a CA bundle file to verify the server certificate with
+	TLSCAFile string
+	// RetryWrites is not supported by DocumentDB, so it can be disabled. Nil keeps the driver default.
+	RetryWrites      *bool
+	DirectConnection bool
+
+	tlsConfig     *tls.Config
+	timeouts      Timeouts
+	clientOptions *options.ClientOptions
+}
+
+// NewURIConfig creates a config from a full connection string. When database is empty,
+// the database in the path of the connection string is used.
+func NewURIConfig(uri string, database string) (URIConfig, error) {
+	cfg := URIConfig{
+		URI:      uri,
+		Database: database,
+		timeouts: timeoutsForLambda(),
+	}
+	cs, err := connstring.ParseAndValidate(uri)
+	if err != nil {
+		return cfg, ErrInvalidConfig{}
+	}
+	if cfg.Database == "" {
+		cfg.Database = cs.Database
+	}
+	if !cfg.IsComplete() {
+		return cfg, ErrInvalidConfig{}
+	}
+	return cfg, nil
+}
+
+// NewHostsConfig creates a config from the hosts (host:port) and credentials.
+// Further options can be set on the returned config.
+func NewHostsConfig(hosts []string, username string, password string, database string) (URIConfig, error) {
+	cfg := URIConfig{
+		Hosts:    hosts,
+		username: username,
+		password: password,
+		Database: database,
+		timeouts: timeoutsForLambda(),
+	}
+	if !cfg.IsComplete() {
+		return cfg, ErrInvalidConfig{}
+	}
+	return cfg, nil
+}
+
+// IsComplete checks if the config is complete
+func (c URIConfig) IsComplete() bool {
+	if c.Database == "" {
+		return false
+	}
+	return c.URI != "" || len(c.Hosts) > 0
+}
+
+// DatabaseName returns the name of the database in the configuration
+func (c URIConfig) DatabaseName() string {
+	return c.Database
+}
+
+// WithTimeouts sets the timeouts for the config
+func (c URIConfig) WithTimeouts(timeouts Timeouts) Config {
+	c.timeouts = timeouts
+	return c
+}
+
+// WithClientOptions sets the additional clientOptions for the config
+func (c URIConfig) WithClientOptions(clientOptions *options.ClientOptions) Config {
+	c.clientOptions = clientOptions
+	return c
+}
+
+// WithRDSCABundle verifies the server certificate with the embedded AWS RDS CA bundle,
+// as required by DocumentDB. It also enables TLS.
+func (c URIConfig) WithRDSCABundle() (URIConfig, error) {
+	pool, err := RDSCertPool()
+	if err != nil {
+		return c, err
+	}
+	c.TLS = true
+	c.tlsConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return c, nil
+}
+
+// ConnectionString returns the connection string, built from the structured fields when no URI is given
+func (c URIConfig) ConnectionString() string {
+	if c.URI != "" {
+		return c.URI
+	}
+	u := &url.URL{
+		Scheme: "mongodb",
+		Host:   strings.Join(c.Hosts, ","),
+		Path:   "/",
+	}
+	if c.username != "" {
+		u.User = url.UserPassword(c.username, c.password)
+	}
+	query := url.Values{}
+	if c.AuthSource != "" {
+		query.Set("authSource", c.AuthSource)
+	}
+	if c.ReplicaSet != "" {
+		query.Set("replicaSet", c.ReplicaSet)
+	}
+	if c.TLS {
+		query.Set("tls", "true")
+	}
+	if c.TLSCAFile != "" {
+		query.Set("tlsCAFile", c.TLSCAFile)
+	}
+	if c.RetryWrites != nil {
+		query.Set("retryWrites", strconv.FormatBool(*c.RetryWrites))
+	}
+	if c.DirectConnection {
+		query.Set("directConnection", "true")
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// toClientOptions constructs the mongo ClientOptions corresponding with the config
+func (c URIConfig) toClientOptions() *options.ClientOptions {
+	opts := options.Client().
+		ApplyURI(c.ConnectionString()).
+		SetMaxConnecting(10)
+	if c.tlsConfig != nil {
+		opts.SetTLSConfig(c.tlsConfig)
+	}
+
+	c.timeouts.setValuesIn(opts)
+	return options.MergeClientOptions(opts, c.clientOptions)
+}
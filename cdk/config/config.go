@@ -1,27 +1,310 @@
 package config
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// SchemaVersion is the version of the config files that this package reads
+const SchemaVersion = 1
+
+var (
+	accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+	regionPattern    = regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il|mx)(-gov)?-(north|south|east|west|central|northeast|southeast|northwest|southwest)-\d$`)
+	// fanoutModes are the values of FANOUT_MODE of the event function
+	fanoutModes = []string{"sync", "stream", "queue"}
+	// Functions are the names of the functions in app/functions, which lambda.functions configures
+	Functions = []string{"admin", "connect", "default", "disconnect", "event", "fanout", "info", "management", "reaper", "request"}
+	// apiFunctions are invoked by API Gateway, which waits 29 seconds for them at most
+	apiFunctions  = []string{"admin", "connect", "default", "disconnect", "event", "info", "management", "request"}
+	tracingModes  = []string{"active", "pass_through", "disabled"}
+	logLevels     = []string{"debug", "info", "warn", "error"}
+	architectures = []string{"arm64", "x86_64"}
+	// logRetentionDays are the retention periods that CloudWatch Logs supports
+	logRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+)
+
+// Config is the configuration of an environment, in config/{env}.yaml
 type Config struct {
+	// Version is the schema version of the file, see SchemaVersion
+	Version   int    `yaml:"version"`
 	Name      string `yaml:"name"`
 	AccountID string `yaml:"account_id"`
 	Region    string `yaml:"region"`
 	Branch    string `yaml:"branch"`
 	DBSecret  string `yaml:"db_secret"`
+	// AdminPubkeys are the hex pubkeys allowed to use the relay management API
+	AdminPubkeys []string `yaml:"admin_pubkeys"`
+	Relay        Relay    `yaml:"relay"`
+	Limits       Limits   `yaml:"limits"`
+	Policies     Policies `yaml:"policies"`
+	Lambda       Lambda   `yaml:"lambda"`
+	Alarms       Alarms   `yaml:"alarms"`
+}
+
+// Relay is the metadata of the relay information document, see NIP-11
+type Relay struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Icon        string `yaml:"icon"`
+	// Pubkey is the hex pubkey of the relay operator
+	Pubkey  string `yaml:"pubkey"`
+	Contact string `yaml:"contact"`
+}
+
+// Limits are the limits of the relay
+type Limits struct {
+	// MaxLimit is the most stored events sent per filter of a REQ
+	MaxLimit int `yaml:"max_limit"`
+	// FanoutConcurrency is the number of connections the fan-out function posts to at once
+	FanoutConcurrency int `yaml:"fanout_concurrency"`
+}
+
+// Policies decide how the relay handles events and requests
+type Policies struct {
+	// FanoutMode is how accepted events are broadcast: sync, stream or queue
+	FanoutMode string `yaml:"fanout_mode"`
+	// CORSAllowedOrigins are the origins of browser based clients of the relay API
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
+	// LogRetentionDays is how long the logs of the functions are kept
+	LogRetentionDays int `yaml:"log_retention_days"`
+	// ReaperSchedule is how often stale connections are reaped
+	ReaperSchedule time.Duration `yaml:"reaper_schedule"`
+	// AllowlistPubkeys only accepts the events of the pubkeys allowed through the
+	// management API. Without it, allowpubkey only clears a ban.
+	AllowlistPubkeys bool `yaml:"allowlist_pubkeys"`
+	// AllowlistKinds only accepts the kinds allowed through the management API.
+	// Without it, allowkind only clears a ban.
+	AllowlistKinds bool `yaml:"allowlist_kinds"`
+}
+
+// Lambda is the settings of all functions, and the settings of functions by name that override them
+type Lambda struct {
+	FunctionSettings `yaml:",inline"`
+	Functions        map[string]FunctionSettings `yaml:"functions"`
+}
+
+// FunctionSettings are the sizing, tracing and logging of a function
+type FunctionSettings struct {
+	MemoryMB int           `yaml:"memory_mb"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// ReservedConcurrency caps the concurrent executions, none when not set or 0. It is a pointer,
+	// so that a function can set it back to 0 when all functions have one.
+	ReservedConcurrency *int `yaml:"reserved_concurrency"`
+	// ProvisionedConcurrency is the number of initialized executions of the live alias,
+	// none when not set or 0. A function can set it back to 0 like ReservedConcurrency.
+	ProvisionedConcurrency *int `yaml:"provisioned_concurrency"`
+	// Tracing is the X-Ray tracing mode: active, pass_through or disabled
+	Tracing string `yaml:"tracing"`
+	// LogLevel is the LOG_LEVEL of the function: debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// Architecture is arm64 or x86_64
+	Architecture string `yaml:"architecture"`
+}
+
+// For returns the settings of the function, with the settings of all functions for those it does not override
+func (l Lambda) For(function string) FunctionSettings {
+	settings := l.FunctionSettings
+	override := l.Functions[function]
+	if override.MemoryMB != 0 {
+		settings.MemoryMB = override.MemoryMB
+	}
+	if override.Timeout != 0 {
+		settings.Timeout = override.Timeout
+	}
+	if override.ReservedConcurrency != nil {
+		settings.ReservedConcurrency = override.ReservedConcurrency
+	}
+	if override.ProvisionedConcurrency != nil {
+		settings.ProvisionedConcurrency = override.ProvisionedConcurrency
+	}
+	if override.Tracing != "" {
+		settings.Tracing = override.Tracing
+	}
+	if override.LogLevel != "" {
+		settings.LogLevel = override.LogLevel
+	}
+	if override.Architecture != "" {
+		settings.Architecture = override.Architecture
+	}
+	return settings
 }
 
+// Alarms are the CloudWatch alarms of the stack, which notify Email
+type Alarms struct {
+	Enabled bool   `yaml:"enabled"`
+	Email   string `yaml:"email"`
+	// Errors is the number of errors of a function in 5 minutes that raises its alarm
+	Errors int `yaml:"errors"`
+	// DeadLetters is the number of undelivered events in the fan-out dead-letter queue that raises its alarm
+	DeadLetters int `yaml:"dead_letters"`
+}
+
+// MustNewConfig reads and validates the config of the environment, and panics when it is invalid
 func MustNewConfig(env string) Config {
-	contents, err := os.ReadFile("config/" + env + ".yaml")
+	c, err := Load("config/" + env + ".yaml")
 	if err != nil {
 		panic(err)
 	}
+	return c
+}
+
+// Load reads the config file at path, and validates it
+func Load(path string) (Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	c, err := Parse(contents)
+	if err != nil {
+		return c, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse decodes the config, refusing unknown keys, sets the defaults and validates it
+func Parse(contents []byte) (Config, error) {
 	var c Config
-	if err := yaml.Unmarshal(contents, &c); err != nil {
-		panic(err)
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil {
+		return c, err
 	}
-	return c
+	c.setDefaults()
+	return c, c.Validate()
+}
+
+func (c *Config) setDefaults() {
+	if c.Relay.Name == "" {
+		c.Relay.Name = "nostr_app_data " + c.Name
+	}
+	if c.Limits.MaxLimit == 0 {
+		c.Limits.MaxLimit = 500
+	}
+	if c.Limits.FanoutConcurrency == 0 {
+		c.Limits.FanoutConcurrency = 16
+	}
+	if c.Policies.FanoutMode == "" {
+		c.Policies.FanoutMode = "queue"
+	}
+	if c.Policies.CORSAllowedOrigins == nil {
+		c.Policies.CORSAllowedOrigins = []string{"*"}
+	}
+	if c.Policies.LogRetentionDays == 0 {
+		c.Policies.LogRetentionDays = 7
+	}
+	if c.Policies.ReaperSchedule == 0 {
+		c.Policies.ReaperSchedule = 15 * time.Minute
+	}
+	if c.Lambda.MemoryMB == 0 {
+		c.Lambda.MemoryMB = 128
+	}
+	if c.Lambda.Timeout == 0 {
+		c.Lambda.Timeout = 10 * time.Second
+	}
+	if c.Lambda.Tracing == "" {
+		c.Lambda.Tracing = "active"
+	}
+	if c.Lambda.LogLevel == "" {
+		c.Lambda.LogLevel = "info"
+	}
+	if c.Lambda.Architecture == "" {
+		c.Lambda.Architecture = "arm64"
+	}
+	if c.Alarms.Errors == 0 {
+		c.Alarms.Errors = 5
+	}
+	if c.Alarms.DeadLetters == 0 {
+		c.Alarms.DeadLetters = 1
+	}
+}
+
+// Validate returns all the problems of the config in one error
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Version == SchemaVersion, "version %d is not supported, expected %d", c.Version, SchemaVersion)
+	for _, required := range []struct{ field, value string }{
+		{"name", c.Name}, {"account_id", c.AccountID}, {"region", c.Region}, {"branch", c.Branch}, {"db_secret", c.DBSecret},
+	} {
+		check(required.value != "", "%s is required", required.field)
+	}
+	check(c.AccountID == "" || accountIDPattern.MatchString(c.AccountID), "account_id %q is not a 12 digit AWS account id", c.AccountID)
+	check(c.Region == "" || regionPattern.MatchString(c.Region), "region %q is not an AWS region", c.Region)
+	for _, pubkey := range c.AdminPubkeys {
+		check(isHexKey(pubkey), "admin_pubkeys: %q is not 64 lowercase hex characters", pubkey)
+	}
+	check(c.Relay.Pubkey == "" || isHexKey(c.Relay.Pubkey), "relay.pubkey %q is not 64 lowercase hex characters", c.Relay.Pubkey)
+	check(c.Limits.MaxLimit > 0, "limits.max_limit must be positive")
+	check(c.Limits.FanoutConcurrency > 0, "limits.fanout_concurrency must be positive")
+	check(slices.Contains(fanoutModes, c.Policies.FanoutMode), "policies.fanout_mode %q is not one of %s", c.Policies.FanoutMode, strings.Join(fanoutModes, ", "))
+	check(slices.Contains(logRetentionDays, c.Policies.LogRetentionDays), "policies.log_retention_days %d is not supported by CloudWatch Logs", c.Policies.LogRetentionDays)
+	check(c.Policies.ReaperSchedule >= time.Minute && c.Policies.ReaperSchedule%time.Minute == 0, "policies.reaper_schedule %s is not a whole number of minutes", c.Policies.ReaperSchedule)
+	errs = append(errs, c.Lambda.FunctionSettings.validate("lambda")...)
+	overridden := make([]string, 0, len(c.Lambda.Functions))
+	for function := range c.Lambda.Functions {
+		overridden = append(overridden, function)
+	}
+	sort.Strings(overridden)
+	for _, function := range overridden {
+		if !slices.Contains(Functions, function) {
+			check(false, "lambda.functions: %q is not one of %s", function, strings.Join(Functions, ", "))
+			continue
+		}
+		errs = append(errs, c.Lambda.For(function).validate("lambda.functions."+function)...)
+	}
+	for _, function := range apiFunctions {
+		timeout := c.Lambda.For(function).Timeout
+		check(timeout <= 29*time.Second, "lambda timeout of %s, %s, is longer than API Gateway waits, 29s", function, timeout)
+	}
+	check(!c.Alarms.Enabled || strings.Contains(c.Alarms.Email, "@"), "alarms.email is required when alarms are enabled")
+	check(c.Alarms.Errors > 0, "alarms.errors must be positive")
+	check(c.Alarms.DeadLetters > 0, "alarms.dead_letters must be positive")
+	return errors.Join(errs...)
+}
+
+func (s FunctionSettings) validate(path string) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(path+"."+format, args...))
+		}
+	}
+	check(s.MemoryMB >= 128 && s.MemoryMB <= 10240, "memory_mb %d is not between 128 and 10240", s.MemoryMB)
+	check(s.Timeout >= time.Second && s.Timeout <= 15*time.Minute, "timeout %s is not between 1s and 15m", s.Timeout)
+	reserved, provisioned := valueOf(s.ReservedConcurrency), valueOf(s.ProvisionedConcurrency)
+	check(reserved >= 0, "reserved_concurrency must not be negative")
+	check(provisioned >= 0, "provisioned_concurrency must not be negative")
+	check(reserved == 0 || provisioned <= reserved, "provisioned_concurrency %d is more than reserved_concurrency %d", provisioned, reserved)
+	check(slices.Contains(tracingModes, s.Tracing), "tracing %q is not one of %s", s.Tracing, strings.Join(tracingModes, ", "))
+	check(slices.Contains(logLevels, s.LogLevel), "log_level %q is not one of %s", s.LogLevel, strings.Join(logLevels, ", "))
+	check(slices.Contains(architectures, s.Architecture), "architecture %q is not one of %s", s.Architecture, strings.Join(architectures, ", "))
+	return errs
+}
+
+// valueOf returns the value that n points to, or 0 when it is nil
+func valueOf(n *int) int {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+func isHexKey(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32 && hex.EncodeToString(b) == s
 }
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFiles(t *testing.T) {
+	paths, err := filepath.Glob("*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("expected config files")
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			c, err := Load(path)
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			if c.Name+".yaml" != path {
+				t.Errorf("expected the name of %s to be the name of the file, got %q", path, c.Name)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := `
+version: 1
+name: test
+account_id: '418272791745'
+region: us-east-1
+branch: develop
+db_secret: test/nostr/mongo/rw
+`
+	cases := map[string]struct {
+		contents string
+		wantErrs []string
+	}{
+		"valid": {
+			contents: valid,
+		},
+		"unknown key": {
+			contents: valid + "db_secrte: test/nostr/mongo/rw\n",
+			wantErrs: []string{"field db_secrte not found"},
+		},
+		"unknown nested key": {
+			contents: valid + "lambda:\n  memory: 256\n",
+			wantErrs: []string{"field memory not found"},
+		},
+		"missing fields": {
+			contents: "version: 1\nname: test\n",
+			wantErrs: []string{"account_id is required", "region is required", "branch is required", "db_secret is required"},
+		},
+		"unsupported version": {
+			contents: strings.Replace(valid, "version: 1", "version: 2", 1),
+			wantErrs: []string{"version 2 is not supported"},
+		},
+		"bad account and region": {
+			contents: strings.NewReplacer("418272791745", "41827279174", "us-east-1", "us-esat-1").Replace(valid),
+			wantErrs: []string{`account_id "41827279174"`, `region "us-esat-1"`},
+		},
+		"bad sections": {
+			contents: valid + `admin_pubkeys: [npub1]
+policies:
+  fanout_mode: async
+  log_retention_days: 10
+lambda:
+  timeout: 20m
+alarms:
+  enabled: true
+`,
+			wantErrs: []string{
+				`admin_pubkeys: "npub1"`,
+				`policies.fanout_mode "async"`,
+				"policies.log_retention_days 10",
+				"lambda.timeout 20m0s",
+				"alarms.email is required",
+			},
+		},
+		"bad function settings": {
+			contents: valid + `lambda:
+  functions:
+    requset:
+      memory_mb: 512
+    request:
+      timeout: 30s
+      tracing: on
+    fanout:
+      reserved_concurrency: 2
+      provisioned_concurrency: 4
+      architecture: amd64
+`,
+			wantErrs: []string{
+				`lambda.functions: "requset" is not one of`,
+				"lambda timeout of request, 30s, is longer than API Gateway waits",
+				`lambda.functions.request.tracing "on"`,
+				"lambda.functions.fanout.provisioned_concurrency 4 is more than reserved_concurrency 2",
+				`lambda.functions.fanout.architecture "amd64"`,
+			},
+		},
+		"function with the provisioned concurrency of all functions": {
+			contents: valid + `lambda:
+  provisioned_concurrency: 4
+  functions:
+    reaper:
+      reserved_concurrency: 2
+`,
+			wantErrs: []string{"lambda.functions.reaper.provisioned_concurrency 4 is more than reserved_concurrency 2"},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(testCase.contents))
+			if len(testCase.wantErrs) == 0 && err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			for _, want := range testCase.wantErrs {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q in error %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	c, err := Parse([]byte("version: 1\nname: test\naccount_id: '418272791745'\nregion: eu-west-1\nbranch: develop\ndb_secret: s\n"))
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	want := Policies{
+		FanoutMode:         "queue",
+		CORSAllowedOrigins: []string{"*"},
+		LogRetentionDays:   7,
+		ReaperSchedule:     15 * time.Minute,
+	}
+	if !reflect.DeepEqual(c.Policies, want) {
+		t.Errorf("incorrect policies\n...got %+v\n..want %+v", c.Policies, want)
+	}
+	if c.Relay.Name != "nostr_app_data test" {
+		t.Errorf("incorrect relay name %q", c.Relay.Name)
+	}
+	wantLambda := FunctionSettings{MemoryMB: 128, Timeout: 10 * time.Second, Tracing: "active", LogLevel: "info", Architecture: "arm64"}
+	if c.Lambda.FunctionSettings != wantLambda {
+		t.Errorf("incorrect lambda %+v", c.Lambda)
+	}
+}
+
+func TestLambdaFor(t *testing.T) {
+	lambda := Lambda{
+		FunctionSettings: FunctionSettings{MemoryMB: 128, Timeout: 10 * time.Second, ProvisionedConcurrency: intPtr(2), Tracing: "active", LogLevel: "info", Architecture: "arm64"},
+		Functions: map[string]FunctionSettings{
+			"fanout": {MemoryMB: 512, Timeout: time.Minute, ReservedConcurrency: intPtr(10), LogLevel: "debug"},
+			"reaper": {ProvisionedConcurrency: intPtr(0)},
+		},
+	}
+	cases := map[string]struct {
+		function string
+		want     FunctionSettings
+	}{
+		"defaults": {
+			function: "connect",
+			want:     FunctionSettings{MemoryMB: 128, Timeout: 10 * time.Second, ProvisionedConcurrency: intPtr(2), Tracing: "active", LogLevel: "info", Architecture: "arm64"},
+		},
+		"overrides": {
+			function: "fanout",
+			want:     FunctionSettings{MemoryMB: 512, Timeout: time.Minute, ReservedConcurrency: intPtr(10), ProvisionedConcurrency: intPtr(2), Tracing: "active", LogLevel: "debug", Architecture: "arm64"},
+		},
+		"concurrency set back to 0": {
+			function: "reaper",
+			want:     FunctionSettings{MemoryMB: 128, Timeout: 10 * time.Second, ProvisionedConcurrency: intPtr(0), Tracing: "active", LogLevel: "info", Architecture: "arm64"},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := lambda.For(testCase.function); !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("incorrect settings\n...got %+v\n..want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+/// Helper Functions ///
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -2,15 +2,27 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigatewayv2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigatewayv2authorizers"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigatewayv2integrations"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatch"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awscloudwatchactions"
 	codebuild "github.com/aws/aws-cdk-go/awscdk/v2/awscodebuild"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambdaeventsources"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awss3assets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssns"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssnssubscriptions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
 	"github.com/aws/aws-cdk-go/awscdk/v2/pipelines"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
@@ -24,48 +36,158 @@ func NewCdkAppStack(scope constructs.Construct, id *string, cfg config.Config, p
 	}
 	stack := awscdk.NewStack(scope, id, props)
 
-	connectHandler := lambdaFunction(stack, name("Connect"), "./functions/connect", map[string]*string{
+	connectHandler, connectTarget := lambdaFunction(stack, cfg, name("Connect"), "connect", map[string]*string{
 		"DB_SECRET": jsii.String(cfg.DBSecret),
 	})
-	disconnectHandler := lambdaFunction(stack, name("Disconnect"), "./functions/disconnect", map[string]*string{
+	disconnectHandler, disconnectTarget := lambdaFunction(stack, cfg, name("Disconnect"), "disconnect", map[string]*string{
 		"DB_SECRET": jsii.String(cfg.DBSecret),
 	})
-	defaultHandler := lambdaFunction(stack, name("Default"), "./functions/default", nil)
-	requestHandler := lambdaFunction(stack, name("Request"), "./functions/request", map[string]*string{
+	defaultHandler, defaultTarget := lambdaFunction(stack, cfg, name("Default"), "default", map[string]*string{
 		"DB_SECRET": jsii.String(cfg.DBSecret),
 	})
-	eventHandler := lambdaFunction(stack, name("Event"), "./functions/event", map[string]*string{
+	requestHandler, requestTarget := lambdaFunction(stack, cfg, name("Request"), "request", map[string]*string{
 		"DB_SECRET": jsii.String(cfg.DBSecret),
+		"MAX_LIMIT": jsii.String(strconv.Itoa(cfg.Limits.MaxLimit)),
+	})
+	// accepted events are queued for the fan-out function, and moved to the
+	// dead-letter queue when they could not be delivered after several attempts
+	fanoutDeadLetterQueue := awssqs.NewQueue(stack, jsii.String(name("FanoutDLQ")), &awssqs.QueueProps{
+		QueueName:       jsii.String(name("FanoutDLQ")),
+		RetentionPeriod: awscdk.Duration_Days(jsii.Number(14)),
+	})
+	// Lambda recommends a visibility timeout of six times the timeout of the function, so batches are retried
+	fanoutQueue := awssqs.NewQueue(stack, jsii.String(name("FanoutQueue")), &awssqs.QueueProps{
+		QueueName:         jsii.String(name("FanoutQueue")),
+		VisibilityTimeout: awscdk.Duration_Seconds(jsii.Number(6 * cfg.Lambda.For("fanout").Timeout.Seconds())),
+		DeadLetterQueue: &awssqs.DeadLetterQueue{
+			MaxReceiveCount: jsii.Number(5),
+			Queue:           fanoutDeadLetterQueue,
+		},
+	})
+	eventEnv := map[string]*string{
+		"DB_SECRET": jsii.String(cfg.DBSecret),
+		// sync broadcasts from the EVENT function, stream leaves it to the broadcaster
+		// worker in cmd/broadcaster, and queue to the fan-out function
+		"FANOUT_MODE":       jsii.String(cfg.Policies.FanoutMode),
+		"ALLOWLIST_PUBKEYS": jsii.String(strconv.FormatBool(cfg.Policies.AllowlistPubkeys)),
+		"ALLOWLIST_KINDS":   jsii.String(strconv.FormatBool(cfg.Policies.AllowlistKinds)),
+	}
+	queueFanout := cfg.Policies.FanoutMode == "queue"
+	if queueFanout {
+		eventEnv["QUEUE_URL"] = fanoutQueue.QueueUrl()
+	}
+	eventHandler, eventTarget := lambdaFunction(stack, cfg, name("Event"), "event", eventEnv)
+	if queueFanout {
+		fanoutQueue.GrantSendMessages(eventHandler)
+	}
+	fanoutHandler, fanoutTarget := lambdaFunction(stack, cfg, name("Fanout"), "fanout", map[string]*string{
+		"DB_SECRET":          jsii.String(cfg.DBSecret),
+		"FANOUT_CONCURRENCY": jsii.String(strconv.Itoa(cfg.Limits.FanoutConcurrency)),
+	})
+	fanoutTarget.AddEventSource(awslambdaeventsources.NewSqsEventSource(fanoutQueue, &awslambdaeventsources.SqsEventSourceProps{
+		BatchSize:               jsii.Number(10),
+		MaxBatchingWindow:       awscdk.Duration_Seconds(jsii.Number(1)),
+		ReportBatchItemFailures: jsii.Bool(true),
+	}))
+	// $disconnect is best-effort, so connections that were never reported as disconnected are reaped
+	reaperHandler, reaperTarget := lambdaFunction(stack, cfg, name("Reaper"), "reaper", map[string]*string{
+		"DB_SECRET":         jsii.String(cfg.DBSecret),
+		"METRICS_NAMESPACE": jsii.String("NostrAppData/" + cfg.Name),
+	})
+	awsevents.NewRule(stack, jsii.String(name("ReaperSchedule")), &awsevents.RuleProps{
+		Schedule: awsevents.Schedule_Rate(awscdk.Duration_Minutes(jsii.Number(cfg.Policies.ReaperSchedule.Minutes()))),
+		Targets:  &[]awsevents.IRuleTarget{awseventstargets.NewLambdaFunction(reaperTarget, nil)},
+	})
+	adminHandler, adminTarget := lambdaFunction(stack, cfg, name("Admin"), "admin", map[string]*string{
+		"DB_SECRET": jsii.String(cfg.DBSecret),
+	})
+	infoHandler, infoTarget := lambdaFunction(stack, cfg, name("Info"), "info", map[string]*string{
+		"DB_SECRET":            jsii.String(cfg.DBSecret),
+		"RELAY_NAME":           jsii.String(cfg.Relay.Name),
+		"RELAY_DESCRIPTION":    jsii.String(cfg.Relay.Description),
+		"RELAY_ICON":           jsii.String(cfg.Relay.Icon),
+		"RELAY_PUBKEY":         jsii.String(cfg.Relay.Pubkey),
+		"RELAY_CONTACT":        jsii.String(cfg.Relay.Contact),
+		"RELAY_VERSION":        jsii.String(version()),
+		"MAX_LIMIT":            jsii.String(strconv.Itoa(cfg.Limits.MaxLimit)),
+		"CORS_ALLOWED_ORIGINS": jsii.String(strings.Join(cfg.Policies.CORSAllowedOrigins, ",")),
+		"ALLOWLIST_PUBKEYS":    jsii.String(strconv.FormatBool(cfg.Policies.AllowlistPubkeys)),
+		"ALLOWLIST_KINDS":      jsii.String(strconv.FormatBool(cfg.Policies.AllowlistKinds)),
+	})
+	managementHandler, managementTarget := lambdaFunction(stack, cfg, name("Management"), "management", map[string]*string{
+		"DB_SECRET":            jsii.String(cfg.DBSecret),
+		"ADMIN_PUBKEYS":        jsii.String(strings.Join(cfg.AdminPubkeys, ",")),
+		"CORS_ALLOWED_ORIGINS": jsii.String(strings.Join(cfg.Policies.CORSAllowedOrigins, ",")),
 	})
 
 	webSocketApi := awsapigatewayv2.NewWebSocketApi(stack, jsii.String(name("WSSAPI")), &awsapigatewayv2.WebSocketApiProps{
 		ConnectRouteOptions: &awsapigatewayv2.WebSocketRouteOptions{
-			Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("ConnectIntegration"), connectHandler, nil),
+			Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("ConnectIntegration"), connectTarget, nil),
 		},
 		DisconnectRouteOptions: &awsapigatewayv2.WebSocketRouteOptions{
-			Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("DisconnectIntegration"), disconnectHandler, nil),
+			Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("DisconnectIntegration"), disconnectTarget, nil),
 		},
 		DefaultRouteOptions: &awsapigatewayv2.WebSocketRouteOptions{
-			Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("DefaultIntegration"), defaultHandler, nil),
+			Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("DefaultIntegration"), defaultTarget, nil),
 		},
 		RouteSelectionExpression: jsii.String("$request.body.[0]"),
 	})
 	webSocketApi.AddRoute(jsii.String("REQ"), &awsapigatewayv2.WebSocketRouteOptions{
-		Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("RequestIntegration"), requestHandler, nil),
+		Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("RequestIntegration"), requestTarget, nil),
 	})
 	webSocketApi.AddRoute(jsii.String("EVENT"), &awsapigatewayv2.WebSocketRouteOptions{
-		Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("EventIntegration"), eventHandler, nil),
+		Integration: awsapigatewayv2integrations.NewWebSocketLambdaIntegration(jsii.String("EventIntegration"), eventTarget, nil),
 	})
-	awsapigatewayv2.NewWebSocketStage(stack, jsii.String("WSSStage"), &awsapigatewayv2.WebSocketStageProps{
+	webSocketStage := awsapigatewayv2.NewWebSocketStage(stack, jsii.String("WSSStage"), &awsapigatewayv2.WebSocketStageProps{
 		AutoDeploy:   jsii.Bool(true),
 		StageName:    jsii.String(cfg.Name),
 		WebSocketApi: webSocketApi,
 	})
 
-	eventHandler.AddEnvironment(
-		jsii.String("WS_API_ENDPOINT"),
-		jsii.String(fmt.Sprintf("https://%s.execute-api.%s.amazonaws.com", *webSocketApi.ApiId(), *props.Env.Region)),
-		nil)
+	// the handlers posting to, or probing, the connections use the management API of the stage, and may only manage its connections
+	manageConnections := awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions: &[]*string{jsii.String("execute-api:ManageConnections")},
+		Effect:  awsiam.Effect_ALLOW,
+		Resources: &[]*string{stack.FormatArn(&awscdk.ArnComponents{
+			Service:      jsii.String("execute-api"),
+			Resource:     webSocketApi.ApiId(),
+			ResourceName: jsii.String(cfg.Name + "/*/@connections/*"),
+		})},
+	})
+	for _, handler := range []awslambda.Function{requestHandler, eventHandler, fanoutHandler, reaperHandler, adminHandler} {
+		handler.AddEnvironment(jsii.String("WS_API_ENDPOINT"), webSocketStage.CallbackUrl(), nil)
+		handler.AddToRolePolicy(manageConnections)
+	}
+
+	// the admin API is for operators, so all its routes need SigV4 signed requests of IAM principals
+	adminApi := awsapigatewayv2.NewHttpApi(stack, jsii.String(name("AdminAPI")), &awsapigatewayv2.HttpApiProps{
+		DefaultAuthorizer: awsapigatewayv2authorizers.NewHttpIamAuthorizer(),
+	})
+	adminIntegration := awsapigatewayv2integrations.NewHttpLambdaIntegration(jsii.String("AdminIntegration"), adminTarget, nil)
+	adminApi.AddRoutes(&awsapigatewayv2.AddRoutesOptions{
+		Integration: adminIntegration,
+		Path:        jsii.String("/admin/connections"),
+		Methods:     &[]awsapigatewayv2.HttpMethod{awsapigatewayv2.HttpMethod_GET},
+	})
+	adminApi.AddRoutes(&awsapigatewayv2.AddRoutesOptions{
+		Integration: adminIntegration,
+		Path:        jsii.String("/admin/connections/{id}"),
+		Methods:     &[]awsapigatewayv2.HttpMethod{awsapigatewayv2.HttpMethod_GET, awsapigatewayv2.HttpMethod_DELETE},
+	})
+
+	// the relay API serves the NIP-11 document, and the NIP-86 management API authorized with NIP-98 by the function
+	relayApi := awsapigatewayv2.NewHttpApi(stack, jsii.String(name("RelayAPI")), nil)
+	relayApi.AddRoutes(&awsapigatewayv2.AddRoutesOptions{
+		Integration: awsapigatewayv2integrations.NewHttpLambdaIntegration(jsii.String("InfoIntegration"), infoTarget, nil),
+		Path:        jsii.String("/"),
+		Methods:     &[]awsapigatewayv2.HttpMethod{awsapigatewayv2.HttpMethod_GET},
+	})
+	relayApi.AddRoutes(&awsapigatewayv2.AddRoutesOptions{
+		Integration: awsapigatewayv2integrations.NewHttpLambdaIntegration(jsii.String("ManagementIntegration"), managementTarget, nil),
+		Path:        jsii.String("/"),
+		// the management function answers the CORS preflight requests of the relay API
+		Methods: &[]awsapigatewayv2.HttpMethod{awsapigatewayv2.HttpMethod_POST, awsapigatewayv2.HttpMethod_OPTIONS},
+	})
 
 	//postHandler := lambdaFunction(stack, "Post", "../app/functions/post",
 	//	map[string]*string{"WS_API_ENDPOINT": jsii.String(fmt.Sprintf("https://%s.execute-api.%s.amazonaws.com/%s", *webSocketApi.ApiId(), *env().Region, *wsStage.StageName()))})
@@ -89,12 +211,31 @@ func NewCdkAppStack(scope constructs.Construct, id *string, cfg config.Config, p
 	//})
 	//webSocketApi.GrantManageConnections(postHandler)
 
+	if cfg.Alarms.Enabled {
+		alarms(stack, cfg, name, fanoutDeadLetterQueue, []awslambda.Function{
+			connectHandler, disconnectHandler, defaultHandler, requestHandler, eventHandler,
+			fanoutHandler, reaperHandler, adminHandler, infoHandler, managementHandler,
+		})
+	}
+
 	awscdk.NewCfnOutput(stack, jsii.String(name("WSSApiURL")), &awscdk.CfnOutputProps{
 		Value:       webSocketApi.ApiEndpoint(),
 		Description: jsii.String("the URL to the WSS API"),
 		ExportName:  jsii.String(name("WSSApiURL")),
 	})
 
+	awscdk.NewCfnOutput(stack, jsii.String(name("AdminApiURL")), &awscdk.CfnOutputProps{
+		Value:       adminApi.ApiEndpoint(),
+		Description: jsii.String("the URL to the admin HTTP API"),
+		ExportName:  jsii.String(name("AdminApiURL")),
+	})
+
+	awscdk.NewCfnOutput(stack, jsii.String(name("RelayApiURL")), &awscdk.CfnOutputProps{
+		Value:       relayApi.ApiEndpoint(),
+		Description: jsii.String("the URL to the relay HTTP API, serving NIP-11 and NIP-86"),
+		ExportName:  jsii.String(name("RelayApiURL")),
+	})
+
 	//awscdk.NewCfnOutput(stack, jsii.String("HTTPApiURL"), &awscdk.CfnOutputProps{
 	//	Value:       httpApi.ApiEndpoint(),
 	//	Description: jsii.String("the URL to the HTTP API"),
@@ -104,43 +245,172 @@ func NewCdkAppStack(scope constructs.Construct, id *string, cfg config.Config, p
 	return stack
 }
 
-func lambdaFunction(stack awscdk.Stack, name, path string, env map[string]*string) awslambda.Function {
+// lambdaFunction creates the function of app/functions/{function} with its settings in the config, and returns
+// it with the target to invoke, which is its live alias when it has provisioned concurrency
+func lambdaFunction(stack awscdk.Stack, cfg config.Config, name, function string, env map[string]*string) (awslambda.Function, awslambda.IFunction) {
+	settings := cfg.Lambda.For(function)
 	lambdaRole := awsiam.NewRole(stack, jsii.String(name+"Role"), &awsiam.RoleProps{
 		AssumedBy: awsiam.NewServicePrincipal(jsii.String("lambda.amazonaws.com"), nil),
 		RoleName:  jsii.String(name + "-lambda-role"),
 	})
 
-	lambdaRole.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
-		Actions:   &[]*string{jsii.String("ssm:GetParameter"), jsii.String("secretsmanager:GetSecretValue"), jsii.String("kms:Decrypt")},
-		Effect:    awsiam.Effect_ALLOW,
-		Resources: &[]*string{jsii.String("arn:aws:secretsmanager:*:*")},
-	}))
+	for _, statement := range dbSecretStatements(cfg) {
+		lambdaRole.AddToPolicy(statement)
+	}
 
-	awslogs.NewLogGroup(stack, jsii.String(name+"LogGroup"), &awslogs.LogGroupProps{
+	logGroup := awslogs.NewLogGroup(stack, jsii.String(name+"LogGroup"), &awslogs.LogGroupProps{
 		LogGroupName:  jsii.String("/aws/lambda/" + name),
-		Retention:     awslogs.RetentionDays_ONE_WEEK,
+		Retention:     retentionDays[cfg.Policies.LogRetentionDays],
 		RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
 	})
-	return awslambda.NewFunction(stack, jsii.String(name+"Function"), &awslambda.FunctionProps{
+	logGroup.GrantWrite(lambdaRole)
+	env["LOG_LEVEL"] = jsii.String(settings.LogLevel)
+	var reservedConcurrency *float64
+	if reserved := settings.ReservedConcurrency; reserved != nil && *reserved > 0 {
+		reservedConcurrency = jsii.Number(*reserved)
+	}
+	fn := awslambda.NewFunction(stack, jsii.String(name+"Function"), &awslambda.FunctionProps{
 		Code: awslambda.Code_FromAsset(jsii.String("../app"), &awss3assets.AssetOptions{
 			Bundling: &awscdk.BundlingOptions{
 				Image: awscdk.DockerImage_FromRegistry(jsii.String("golang:1.21.0")),
+				// the RDS CA bundle is fetched once before synth, by ops/fetch-rds-ca-bundle.sh
 				Command: &[]*string{
 					jsii.String("bash"),
 					jsii.String("-c"),
-					jsii.String("GOCACHE=/tmp go mod tidy && GOCACHE=/tmp GOARCH=arm64 GOOS=linux go build -tags lambda.norpc -o /asset-output/bootstrap " + path),
+					jsii.String("test -f utils/skmongo/certs/global-bundle.pem || { echo 'run ops/fetch-rds-ca-bundle.sh first' >&2; exit 1; } && " +
+						"GOCACHE=/tmp GOARCH=" + goarch[settings.Architecture] + " GOOS=linux go build -mod=readonly -tags lambda.norpc -o /asset-output/bootstrap ./functions/" + function),
 				},
 			},
 		}),
-		FunctionName: jsii.String(name),
-		Runtime:      awslambda.Runtime_PROVIDED_AL2023(),
-		MemorySize:   jsii.Number(128),
-		Timeout:      awscdk.Duration_Seconds(jsii.Number(3)),
-		Handler:      jsii.String("bootstrap"),
-		Architecture: awslambda.Architecture_ARM_64(),
-		Environment:  &env,
-		Role:         lambdaRole,
+		FunctionName:                 jsii.String(name),
+		Runtime:                      awslambda.Runtime_PROVIDED_AL2023(),
+		MemorySize:                   jsii.Number(settings.MemoryMB),
+		Timeout:                      awscdk.Duration_Seconds(jsii.Number(settings.Timeout.Seconds())),
+		ReservedConcurrentExecutions: reservedConcurrency,
+		Tracing:                      tracing[settings.Tracing],
+		Handler:                      jsii.String("bootstrap"),
+		Architecture:                 architectures[settings.Architecture](),
+		Environment:                  &env,
+		Role:                         lambdaRole,
+	})
+	provisioned := settings.ProvisionedConcurrency
+	if provisioned == nil || *provisioned == 0 {
+		return fn, fn
+	}
+	// provisioned concurrency is configured on an alias, which is published with each change of the function
+	alias := awslambda.NewAlias(stack, jsii.String(name+"Alias"), &awslambda.AliasProps{
+		AliasName:                       jsii.String("live"),
+		Version:                         fn.CurrentVersion(),
+		ProvisionedConcurrentExecutions: jsii.Number(*provisioned),
+	})
+	return fn, alias
+}
+
+// dbSecretStatements allow reading the DB secret of the config, in Secrets Manager or in SSM Parameter
+// Store by its scheme, see skmongo.ProviderFor. Secrets from the environment or a file need none.
+func dbSecretStatements(cfg config.Config) []awsiam.PolicyStatement {
+	var actions []*string
+	var resource string
+	switch {
+	case strings.HasPrefix(cfg.DBSecret, "ssm://"):
+		actions = []*string{jsii.String("ssm:GetParameter")}
+		resource = fmt.Sprintf("arn:aws:ssm:%s:%s:parameter/%s", cfg.Region, cfg.AccountID, strings.TrimPrefix(strings.TrimPrefix(cfg.DBSecret, "ssm://"), "/"))
+	case strings.Contains(cfg.DBSecret, "://") && !strings.HasPrefix(cfg.DBSecret, "secretsmanager://"):
+		return nil
+	default:
+		// Secrets Manager appends a random suffix to the ARN of a secret
+		actions = []*string{jsii.String("secretsmanager:GetSecretValue")}
+		resource = fmt.Sprintf("arn:aws:secretsmanager:%s:%s:secret:%s-*", cfg.Region, cfg.AccountID, strings.TrimPrefix(cfg.DBSecret, "secretsmanager://"))
+	}
+	return []awsiam.PolicyStatement{awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Actions:   &actions,
+		Effect:    awsiam.Effect_ALLOW,
+		Resources: &[]*string{jsii.String(resource)},
+	})}
+}
+
+// alarms notifies the email address of the config when a function has errors, or events could not be delivered
+func alarms(stack awscdk.Stack, cfg config.Config, name func(string) string, deadLetterQueue awssqs.Queue, functions []awslambda.Function) {
+	topic := awssns.NewTopic(stack, jsii.String(name("AlarmTopic")), &awssns.TopicProps{
+		TopicName: jsii.String(name("AlarmTopic")),
 	})
+	topic.AddSubscription(awssnssubscriptions.NewEmailSubscription(jsii.String(cfg.Alarms.Email), nil))
+	action := awscloudwatchactions.NewSnsAction(topic)
+
+	for _, function := range functions {
+		alarm := function.MetricErrors(&awscloudwatch.MetricOptions{
+			Period: awscdk.Duration_Minutes(jsii.Number(5)),
+		}).CreateAlarm(function, jsii.String("ErrorsAlarm"), &awscloudwatch.CreateAlarmOptions{
+			Threshold:          jsii.Number(cfg.Alarms.Errors),
+			EvaluationPeriods:  jsii.Number(1),
+			ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+			TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+		})
+		alarm.AddAlarmAction(action)
+	}
+	deadLetters := deadLetterQueue.MetricApproximateNumberOfMessagesVisible(&awscloudwatch.MetricOptions{
+		Period: awscdk.Duration_Minutes(jsii.Number(5)),
+	}).CreateAlarm(deadLetterQueue, jsii.String("DeadLettersAlarm"), &awscloudwatch.CreateAlarmOptions{
+		Threshold:          jsii.Number(cfg.Alarms.DeadLetters),
+		EvaluationPeriods:  jsii.Number(1),
+		ComparisonOperator: awscloudwatch.ComparisonOperator_GREATER_THAN_OR_EQUAL_TO_THRESHOLD,
+		TreatMissingData:   awscloudwatch.TreatMissingData_NOT_BREACHING,
+	})
+	deadLetters.AddAlarmAction(action)
+}
+
+// tracing are the X-Ray tracing modes of the config
+var tracing = map[string]awslambda.Tracing{
+	"active":       awslambda.Tracing_ACTIVE,
+	"pass_through": awslambda.Tracing_PASS_THROUGH,
+	"disabled":     awslambda.Tracing_DISABLED,
+}
+
+// architectures are the architectures of the config, and goarch what the functions are built for on them
+var (
+	architectures = map[string]func() awslambda.Architecture{
+		"arm64":  awslambda.Architecture_ARM_64,
+		"x86_64": awslambda.Architecture_X86_64,
+	}
+	goarch = map[string]string{
+		"arm64":  "arm64",
+		"x86_64": "amd64",
+	}
+)
+
+// retentionDays are the log retention periods of the config, which validates that they are supported
+var retentionDays = map[int]awslogs.RetentionDays{
+	1:    awslogs.RetentionDays_ONE_DAY,
+	3:    awslogs.RetentionDays_THREE_DAYS,
+	5:    awslogs.RetentionDays_FIVE_DAYS,
+	7:    awslogs.RetentionDays_ONE_WEEK,
+	14:   awslogs.RetentionDays_TWO_WEEKS,
+	30:   awslogs.RetentionDays_ONE_MONTH,
+	60:   awslogs.RetentionDays_TWO_MONTHS,
+	90:   awslogs.RetentionDays_THREE_MONTHS,
+	120:  awslogs.RetentionDays_FOUR_MONTHS,
+	150:  awslogs.RetentionDays_FIVE_MONTHS,
+	180:  awslogs.RetentionDays_SIX_MONTHS,
+	365:  awslogs.RetentionDays_ONE_YEAR,
+	400:  awslogs.RetentionDays_THIRTEEN_MONTHS,
+	545:  awslogs.RetentionDays_EIGHTEEN_MONTHS,
+	731:  awslogs.RetentionDays_TWO_YEARS,
+	1096: awslogs.RetentionDays_THREE_YEARS,
+	1827: awslogs.RetentionDays_FIVE_YEARS,
+	2192: awslogs.RetentionDays_SIX_YEARS,
+	2557: awslogs.RetentionDays_SEVEN_YEARS,
+	2922: awslogs.RetentionDays_EIGHT_YEARS,
+	3288: awslogs.RetentionDays_NINE_YEARS,
+	3653: awslogs.RetentionDays_TEN_YEARS,
+}
+
+// version returns the version of the app in the VERSION file, or an empty string when it can not be read
+func version() string {
+	contents, err := os.ReadFile("../VERSION")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
 }
 
 func NewCdkApplication(scope constructs.Construct, id *string, cfg config.Config, props *awscdk.StageProps) awscdk.Stage {
@@ -176,6 +446,7 @@ func NewCdkPipeline(scope constructs.Construct, id *string, cfg config.Config, p
 		},
 		Synth: pipelines.NewCodeBuildStep(jsii.String("Synth"), &pipelines.CodeBuildStepProps{
 			Commands: &[]*string{
+				jsii.String("ops/fetch-rds-ca-bundle.sh"),
 				jsii.String("cd cdk"),
 				jsii.String("npm install -g aws-cdk"),
 				jsii.String("cdk synth --context environment=" + cfg.Name),
@@ -184,15 +455,21 @@ func NewCdkPipeline(scope constructs.Construct, id *string, cfg config.Config, p
 			PrimaryOutputDirectory: jsii.String("cdk/cdk.out"),
 		}),
 	})
+	migrationStatements := dbSecretStatements(cfg)
 	// deployment of actual CDK application
 	myPipeline.AddStage(NewCdkApplication(stack, jsii.String(fmt.Sprintf("%s-%s", cfg.Name, "NostrAppData")), cfg, &awscdk.StageProps{
 		Env: props.Env,
 	}), &pipelines.AddStageOpts{
 		Post: &[]pipelines.Step{
-			pipelines.NewCodeBuildStep(jsii.String("Manual Steps"), &pipelines.CodeBuildStepProps{
+			// apply pending database migrations from ops/migrations
+			pipelines.NewCodeBuildStep(jsii.String("Migrations"), &pipelines.CodeBuildStepProps{
+				Input: githubRepo,
 				Commands: &[]*string{
-					jsii.String("echo \"NostrAppData deployed, manual steps go here ... \""),
+					jsii.String("ops/fetch-rds-ca-bundle.sh"),
+					jsii.String("cd app"),
+					jsii.String("go run ./cmd/migrate -secret " + cfg.DBSecret + " -dir ../ops/migrations up"),
 				},
+				RolePolicyStatements: &migrationStatements,
 			}),
 		},
 	})
@@ -1,26 +1,488 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in nostr_app_data_test.go
-// func TestNostrAppDataStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewNostrAppDataStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack, nil)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/jsii-runtime-go"
+
+	"github.com/superkruger/nostr_app_data/cdk/config"
+)
+
+// stackID is the id of the stacks under test, so the names of the resources do not change with the config
+const stackID = "NostrAppData-Stack"
+
+// update writes the synthesized templates to the snapshots, review the diff before committing them
+var update = flag.Bool("update", false, "update the snapshots in testdata")
+
+// the hashes of the assets change with each change of the functions, and the
+// version with each release, so they are left out of the snapshots. Only the
+// asset references are masked, other hex values such as pubkeys are kept.
+var (
+	assetHash    = regexp.MustCompile(`("S3Key": "|AssetParameters)[0-9a-f]{64}`)
+	relayVersion = regexp.MustCompile(`("RELAY_VERSION": )"[^"]*"`)
+)
+
+// postingFunctions are the functions that post to, or probe, the websocket connections
+var postingFunctions = []string{"Request", "Event", "Fanout", "Reaper", "Admin"}
+
+func TestIAM(t *testing.T) {
+	// the fanout queue is only used in the queue mode
+	variants := map[string]func(cfg *config.Config){
+		"":            nil,
+		"sync fanout": func(cfg *config.Config) { cfg.Policies.FanoutMode = "sync" },
+	}
+	for _, path := range configPaths(t) {
+		for variant, modify := range variants {
+			t.Run(strings.TrimSpace(path+" "+variant), func(t *testing.T) {
+				cfg, template := synthesizeVariant(t, path, variant, modify)
+				statements := roleStatements(t, template)
+				secretArn := fmt.Sprintf("arn:aws:secretsmanager:%s:%s:secret:%s-*", cfg.Region, cfg.AccountID, cfg.DBSecret)
+
+				for _, function := range config.Functions {
+					name := functionName(function)
+					role := stackID + "-" + name + "-lambda-role"
+					t.Run(name, func(t *testing.T) {
+						got, found := statements[role]
+						if !found {
+							t.Fatalf("expected a policy for role %s", role)
+						}
+						if s := got.find("secretsmanager:GetSecretValue"); s == nil {
+							t.Errorf("expected the DB secret to be readable")
+						} else if !reflect.DeepEqual(s.resources(), []string{secretArn}) {
+							t.Errorf("expected only %s to be readable, got %v", secretArn, s.resources())
+						}
+						for _, s := range got {
+							for _, action := range s.actions() {
+								if strings.HasPrefix(action, "ssm:") || strings.HasPrefix(action, "kms:") {
+									t.Errorf("did not expect %s", action)
+								}
+							}
+							for _, resource := range s.resources() {
+								if resource == "arn:aws:secretsmanager:*:*" {
+									t.Errorf("did not expect all secrets to be readable")
+								}
+							}
+						}
+
+						sending := got.find("sqs:SendMessage") != nil
+						if want := name == "Event" && cfg.Policies.FanoutMode == "queue"; sending != want {
+							t.Errorf("expected the fanout queue to be writable: %t, got %t", want, sending)
+						}
+
+						s := got.find("execute-api:ManageConnections")
+						posting := contains(postingFunctions, name)
+						if !posting {
+							if s != nil {
+								t.Errorf("did not expect the connections to be manageable")
+							}
+							return
+						}
+						if s == nil {
+							t.Fatalf("expected the connections to be manageable")
+						}
+						resource, _ := json.Marshal(s.Resource)
+						if want := "/" + cfg.Name + "/*/@connections/*"; !strings.Contains(string(resource), want) {
+							t.Errorf("expected only the connections of the stage, %s, to be manageable, got %s", want, resource)
+						}
+					})
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	for _, path := range configPaths(t) {
+		t.Run(path, func(t *testing.T) {
+			_, template := synthesize(t, path)
+			got, err := json.MarshalIndent(template.ToJSON(), "", "  ")
+			if err != nil {
+				t.Fatalf("did not expect error %v", err)
+			}
+			got = assetHash.ReplaceAll(got, []byte("$1<asset hash>"))
+			got = append(relayVersion.ReplaceAll(got, []byte(`$1"<version>"`)), '\n')
+
+			snapshot := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(path), ".yaml")+".template.json")
+			if *update {
+				if err := os.WriteFile(snapshot, got, 0o644); err != nil {
+					t.Fatalf("did not expect error %v", err)
+				}
+				return
+			}
+			want, err := os.ReadFile(snapshot)
+			if err != nil {
+				t.Fatalf("did not expect error %v, create the snapshot with go test -run TestSnapshots -update", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("the template differs from %s, review the diff after go test -run TestSnapshots -update", snapshot)
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	want := []string{
+		"$connect", "$default", "$disconnect", "EVENT", "REQ",
+		"DELETE /admin/connections/{id}", "GET /admin/connections", "GET /admin/connections/{id}",
+		"GET /", "OPTIONS /", "POST /",
+	}
+	sort.Strings(want)
+	for _, path := range configPaths(t) {
+		t.Run(path, func(t *testing.T) {
+			_, template := synthesize(t, path)
+			var routes map[string]struct {
+				Properties struct {
+					RouteKey string
+				}
+			}
+			decode(t, template.FindResources(jsii.String("AWS::ApiGatewayV2::Route"), nil), &routes)
+			var got []string
+			for _, route := range routes {
+				got = append(got, route.Properties.RouteKey)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("incorrect routes\n...got %v\n..want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	for _, path := range configPaths(t) {
+		t.Run(path, func(t *testing.T) {
+			cfg, template := synthesize(t, path)
+			functions := functionProperties(t, template)
+			if len(functions) != len(config.Functions) {
+				t.Errorf("expected %d functions, got %d", len(config.Functions), len(functions))
+			}
+			for _, function := range config.Functions {
+				name := functionName(function)
+				t.Run(name, func(t *testing.T) {
+					got, found := functions[stackID+"-"+name]
+					if !found {
+						t.Fatalf("expected function %s", name)
+					}
+					settings := cfg.Lambda.For(function)
+					if got.MemorySize != settings.MemoryMB {
+						t.Errorf("expected %d MB of memory, got %d", settings.MemoryMB, got.MemorySize)
+					}
+					if time.Duration(got.Timeout)*time.Second != settings.Timeout {
+						t.Errorf("expected a timeout of %s, got %ds", settings.Timeout, got.Timeout)
+					}
+					if want := map[string]string{"active": "Active", "pass_through": "PassThrough"}[settings.Tracing]; got.TracingConfig.Mode != want {
+						t.Errorf("expected tracing mode %q, got %q", want, got.TracingConfig.Mode)
+					}
+
+					want := map[string]string{
+						"DB_SECRET": cfg.DBSecret,
+						"LOG_LEVEL": settings.LogLevel,
+					}
+					switch function {
+					case "request":
+						want["MAX_LIMIT"] = fmt.Sprint(cfg.Limits.MaxLimit)
+					case "event":
+						want["FANOUT_MODE"] = cfg.Policies.FanoutMode
+						if _, found := got.Environment.Variables["QUEUE_URL"]; !found {
+							t.Errorf("expected QUEUE_URL to be set")
+						}
+						want["ALLOWLIST_PUBKEYS"] = fmt.Sprint(cfg.Policies.AllowlistPubkeys)
+						want["ALLOWLIST_KINDS"] = fmt.Sprint(cfg.Policies.AllowlistKinds)
+					case "fanout":
+						want["FANOUT_CONCURRENCY"] = fmt.Sprint(cfg.Limits.FanoutConcurrency)
+					case "info":
+						want["RELAY_NAME"] = cfg.Relay.Name
+						want["ALLOWLIST_PUBKEYS"] = fmt.Sprint(cfg.Policies.AllowlistPubkeys)
+						want["ALLOWLIST_KINDS"] = fmt.Sprint(cfg.Policies.AllowlistKinds)
+						want["MAX_LIMIT"] = fmt.Sprint(cfg.Limits.MaxLimit)
+						want["CORS_ALLOWED_ORIGINS"] = strings.Join(cfg.Policies.CORSAllowedOrigins, ",")
+					case "management":
+						want["ADMIN_PUBKEYS"] = strings.Join(cfg.AdminPubkeys, ",")
+						want["CORS_ALLOWED_ORIGINS"] = strings.Join(cfg.Policies.CORSAllowedOrigins, ",")
+					}
+					for variable, value := range want {
+						if got.Environment.Variables[variable] != value {
+							t.Errorf("expected %s=%v, got %v", variable, value, got.Environment.Variables[variable])
+						}
+					}
+					_, hasEndpoint := got.Environment.Variables["WS_API_ENDPOINT"]
+					if hasEndpoint != contains(postingFunctions, name) {
+						t.Errorf("did not expect WS_API_ENDPOINT to be set: %t", hasEndpoint)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestLogRetention(t *testing.T) {
+	for _, path := range configPaths(t) {
+		t.Run(path, func(t *testing.T) {
+			cfg, template := synthesize(t, path)
+			var logGroups map[string]struct {
+				Properties struct {
+					LogGroupName    string
+					RetentionInDays int
+				}
+			}
+			decode(t, template.FindResources(jsii.String("AWS::Logs::LogGroup"), nil), &logGroups)
+			retention := map[string]int{}
+			for _, logGroup := range logGroups {
+				retention[logGroup.Properties.LogGroupName] = logGroup.Properties.RetentionInDays
+			}
+			for _, function := range config.Functions {
+				name := "/aws/lambda/" + stackID + "-" + functionName(function)
+				days, found := retention[name]
+				if !found {
+					t.Errorf("expected log group %s", name)
+				} else if days != cfg.Policies.LogRetentionDays {
+					t.Errorf("expected %s to be retained for %d days, got %d", name, cfg.Policies.LogRetentionDays, days)
+				}
+			}
+		})
+	}
+}
+
+func TestDBSecretStatements(t *testing.T) {
+	cfg := config.Config{Region: "us-east-1", AccountID: "418272791745"}
+	cases := map[string]struct {
+		dbSecret      string
+		wantActions   []string
+		wantResources []string
+	}{
+		"secrets manager": {
+			dbSecret:      "prod/nostr/mongo/rw",
+			wantActions:   []string{"secretsmanager:GetSecretValue"},
+			wantResources: []string{"arn:aws:secretsmanager:us-east-1:418272791745:secret:prod/nostr/mongo/rw-*"},
+		},
+		"secrets manager scheme": {
+			dbSecret:      "secretsmanager://prod/nostr/mongo/rw",
+			wantActions:   []string{"secretsmanager:GetSecretValue"},
+			wantResources: []string{"arn:aws:secretsmanager:us-east-1:418272791745:secret:prod/nostr/mongo/rw-*"},
+		},
+		"ssm": {
+			dbSecret:      "ssm:///prod/nostr/mongo/rw",
+			wantActions:   []string{"ssm:GetParameter"},
+			wantResources: []string{"arn:aws:ssm:us-east-1:418272791745:parameter/prod/nostr/mongo/rw"},
+		},
+		"env": {
+			dbSecret: "env://MONGO",
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg.DBSecret = testCase.dbSecret
+			statements := dbSecretStatements(cfg)
+			if testCase.wantActions == nil {
+				if len(statements) != 0 {
+					t.Fatalf("did not expect statements, got %d", len(statements))
+				}
+				return
+			}
+			if len(statements) != 1 {
+				t.Fatalf("expected a statement, got %d", len(statements))
+			}
+			if got := jsii.Strings(testCase.wantActions...); !reflect.DeepEqual(statements[0].Actions(), got) {
+				t.Errorf("incorrect actions %v", *statements[0].Actions())
+			}
+			if got := jsii.Strings(testCase.wantResources...); !reflect.DeepEqual(statements[0].Resources(), got) {
+				t.Errorf("incorrect resources %v", *statements[0].Resources())
+			}
+		})
+	}
+}
+
+/// Helper Functions ///
+
+// synthesize creates the app stack with the config file at path, once for all
+// tests. Bundling the functions needs Docker, so the assets are not bundled.
+func synthesize(t *testing.T, path string) (config.Config, assertions.Template) {
+	t.Helper()
+	return synthesizeVariant(t, path, "", nil)
+}
+
+// synthesizeVariant creates the app stack with the config file at path, changed
+// by modify when it is not nil, once for all tests of the variant
+func synthesizeVariant(t *testing.T, path, variant string, modify func(cfg *config.Config)) (config.Config, assertions.Template) {
+	t.Helper()
+	key := path + "#" + variant
+	if s, found := synthesized[key]; found {
+		return s.cfg, s.template
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	if modify != nil {
+		modify(&cfg)
+	}
+	app := awscdk.NewApp(&awscdk.AppProps{
+		Context: &map[string]interface{}{"aws:cdk:bundling-stacks": []string{}},
+	})
+	stack := NewCdkAppStack(app, jsii.String(stackID), cfg, &awscdk.StackProps{Env: env(cfg)})
+	template := assertions.Template_FromStack(stack, nil)
+	synthesized[key] = synthesizedStack{cfg: cfg, template: template}
+	return cfg, template
+}
+
+func configPaths(t *testing.T) []string {
+	t.Helper()
+	paths, err := filepath.Glob("config/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("expected config files")
+	}
+	return paths
+}
+
+// functionName returns the name that the stack gives the function of app/functions
+func functionName(function string) string {
+	return strings.ToUpper(function[:1]) + function[1:]
+}
+
+// functionProperties returns the properties of the functions by their names
+func functionProperties(t *testing.T, template assertions.Template) map[string]function {
+	t.Helper()
+	var resources map[string]struct {
+		Properties function
+	}
+	decode(t, template.FindResources(jsii.String("AWS::Lambda::Function"), nil), &resources)
+	functions := map[string]function{}
+	for _, resource := range resources {
+		functions[resource.Properties.FunctionName] = resource.Properties
+	}
+	return functions
+}
+
+// roleStatements returns the policy statements of the roles by their names
+func roleStatements(t *testing.T, template assertions.Template) map[string]statements {
+	t.Helper()
+	var roles map[string]struct {
+		Properties struct {
+			RoleName string
+		}
+	}
+	decode(t, template.FindResources(jsii.String("AWS::IAM::Role"), nil), &roles)
+	var policies map[string]struct {
+		Properties struct {
+			PolicyDocument struct {
+				Statement statements
+			}
+			Roles []struct {
+				Ref string
+			}
+		}
+	}
+	decode(t, template.FindResources(jsii.String("AWS::IAM::Policy"), nil), &policies)
+
+	byRole := map[string]statements{}
+	for _, policy := range policies {
+		for _, role := range policy.Properties.Roles {
+			name := roles[role.Ref].Properties.RoleName
+			byRole[name] = append(byRole[name], policy.Properties.PolicyDocument.Statement...)
+		}
+	}
+	return byRole
+}
+
+// decode converts the resources of a template to v
+func decode(t *testing.T, resources *map[string]*map[string]interface{}, v interface{}) {
+	t.Helper()
+	b, err := json.Marshal(resources)
+	if err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("did not expect error %v", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+/// Helper Types ///
+
+type synthesizedStack struct {
+	cfg      config.Config
+	template assertions.Template
+}
+
+// synthesized are the stacks that synthesizeVariant created, by the paths of their config files and variants
+var synthesized = map[string]synthesizedStack{}
+
+// function are the properties of a function that the tests check
+type function struct {
+	FunctionName  string
+	MemorySize    int
+	Timeout       int
+	TracingConfig struct {
+		Mode string
+	}
+	Environment struct {
+		Variables map[string]interface{}
+	}
+}
+
+// statement is a statement of an IAM policy, whose action and resource are a value or a list
+type statement struct {
+	Action   interface{}
+	Effect   string
+	Resource interface{}
+}
+
+type statements []statement
+
+func (s statement) actions() []string {
+	return stringValues(s.Action)
+}
+
+// resources returns the resources that are strings, and not references to resources of the stack
+func (s statement) resources() []string {
+	return stringValues(s.Resource)
+}
+
+func (s statements) find(action string) *statement {
+	for i := range s {
+		if contains(s[i].actions(), action) {
+			return &s[i]
+		}
+	}
+	return nil
+}
+
+func stringValues(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+#!/bin/bash
+
+# Downloads the AWS RDS global CA bundle, used to verify DocumentDB certificates
+cd "$(dirname "$0")/.." || exit 1
+curl -sSf -o app/utils/skmongo/certs/global-bundle.pem https://truststore.pki.rds.amazonaws.com/global/global-bundle.pem